@@ -2,6 +2,7 @@ package main
 
 import (
 	"backup_categorizer/pkg"
+	"fmt"
 	"os"
 	"time"
 )
@@ -9,43 +10,95 @@ import (
 func main() {
 	startTime := time.Now()
 
+	subCommand := pkg.GetSubCommand()
+	if subCommand == pkg.UndoCmd {
+		undo()
+		return
+	}
+
 	o, err := pkg.GetNewOperator()
 	if err != nil {
 		panic(err)
 	}
 
-	o.Flags = pkg.GetFlags(os.Args[3:])
-	if err := pkg.ValidateDir(o.Flags.SrcPath); err != nil {
-		panic(err)
+	var rules *pkg.Config
+	switch subCommand {
+	case pkg.OrgDirCmd:
+		o.Flags = pkg.GetFlags(os.Args[2:])
+		rules, err = pkg.ReadCategories(o.Flags.RulePath)
+		if err != nil {
+			panic(err)
+		}
+	case pkg.SortImgCmd:
+		o.Flags = pkg.GetSortImgFlags(os.Args[2:])
+		rules = pkg.MediaRules(o.Flags.Sort, o.Flags.DateSources)
+	default:
+		fmt.Println("expected 'org-dir', 'sort-img' or 'undo' subcommand")
+		os.Exit(1)
 	}
 
-	rules, err := pkg.ReadCategories(o.Flags.RulePath)
+	o.Pattern, err = pkg.CompilePattern(o.Flags.Pattern)
 	if err != nil {
 		panic(err)
 	}
+
+	if err := pkg.ValidateDir(o.Flags.SrcPath); err != nil {
+		panic(err)
+	}
+
 	if err := o.CreateSubdirs(o.Flags.DstPath, rules.Rules); err != nil {
 		panic(err)
 	}
 	o.BuildStorageMaps(rules)
 
-	o.CsvHandler, err = pkg.NewCSVLogger(o.Flags.LogPath)
-	if err != nil {
-		panic(err)
+	if o.Flags.Resume != "" {
+		if err := o.LoadResume(o.Flags.Resume); err != nil {
+			panic(err)
+		}
 	}
 
-	extensions, err := o.Operate()
+	// dry-run must not write anything, the plan is printed to stdout instead of the log.
+	if !o.Flags.DryRun {
+		o.CsvHandler, err = pkg.NewCSVLogger(o.Flags.LogPath, o.Flags.Resume != "" || o.Flags.LogAppend)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	ctx, stop := pkg.InterruptContext()
+	defer stop()
+	extensions, err := o.Operate(ctx)
 	if err != nil {
 		panic(err)
 	}
 
 	pkg.ResultLog(extensions, o, startTime)
-	if o.Storage.Exif != nil {
-		defer func() {
-			err := o.Storage.Exif.Close()
-			if err != nil {
-				panic(err)
-			}
-		}()
+	if o.Flags.ReportPath != "" {
+		if err := o.WriteReport(o.Flags.ReportPath); err != nil {
+			panic(err)
+		}
+	}
+	if o.CsvHandler != nil {
+		if err := o.CsvHandler.Close(); err != nil {
+			panic(err)
+		}
+	}
+	if o.Interrupted() {
+		os.Exit(pkg.ExitInterrupted)
+	}
+	if o.Failures() > 0 {
+		os.Exit(1)
+	}
+}
+
+func undo() {
+	flags := pkg.GetUndoFlags(os.Args[2:])
+	refused, err := pkg.Undo(flags.LogPath, flags.UndoRun, pkg.UndoCategories(flags.RulePath), flags.DryRun)
+	if err != nil {
+		panic(err)
+	}
+	if refused > 0 {
+		os.Exit(1)
 	}
 }
 
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	criterionNameContains = "name_contains"
+	criterionExtension    = "extension"
+	criterionContent      = "content"
+)
+
+// criterionRank is the default precedence between criteria, lower wins.
+// name_contains is more specific than an extension, so it goes first.
+// content only decides files whose extension no rule knows, unless the rule has sniff_override, see rank.
+var criterionRank = map[string]int{
+	criterionNameContains: 0,
+	criterionExtension:    2,
+	criterionContent:      3,
+}
+
+// Match is a rule which matched a file.
+// Criterion tells which part of the rule matched, e.g. "name_contains:user1234", "extension:pdf" or "content:pdf",
+// Reason tells why this match won over the other matches of the same file.
+// DateSource is the date source of the rule's sort which dated the file.
+// Content is the format sniffed out of the file header with --sniff, empty if it wasn't sniffed or isn't known.
+type Match struct {
+	Category    string
+	Criterion   string
+	Reason      string
+	DateSource  string
+	Content     string
+	mismatch    bool   // Content isn't a format the file's extension stands for
+	contentKind string // kind of Content, e.g. image or executable
+}
+
+func (m Match) kind() string {
+	kind, _, _ := strings.Cut(m.Criterion, ":")
+	return kind
+}
+
+// separateExt is the extension separate goes by for a file with the extension ext,
+// the sniffed one if the content decided the match, e.g. "pdf" for an extensionless PDF.
+func (m Match) separateExt(ext string) string {
+	if m.kind() == criterionContent {
+		return m.Content
+	}
+	return ext
+}
+
+// rank is the precedence of m's criterion, content matches of sniff_override rules go right after name_contains.
+func (o *Operator) rank(m Match) int {
+	if m.kind() == criterionContent && o.Storage.SniffOverride[m.Category] {
+		return 1
+	}
+	return criterionRank[m.kind()]
+}
+
+// classify returns the rule match of the file at fp, with --sniff its header is matched too.
+func (o *Operator) classify(ext, fp string) Match {
+	var content, kind string
+	var agrees = true
+	if o.Flags.Sniff {
+		if s, known := sniffFile(fp); known {
+			// extensionless files have nothing to disagree with.
+			content, kind, agrees = s.Ext(), s.Kind, ext == "" || s.agrees(ext)
+		}
+	}
+	match := o.pickMatch(o.matchRules(path.Base(fp), ext, content))
+	match.Content, match.contentKind, match.mismatch = content, kind, !agrees
+	if !agrees {
+		match.Reason = strings.Join([]string{match.Reason, fmt.Sprintf("content is %s (%s), not %s", content, kind, ext)}, "; ")
+	}
+	return match
+}
+
+// matchRules returns every rule matching the file, in rules file order.
+// A rule shows up at most once, with its most specific criterion. content is the sniffed format, if any.
+func (o *Operator) matchRules(fileName, ext, content string) []Match {
+	matches := make([]Match, 0)
+	for _, category := range o.Storage.RuleOrder {
+		if substring, ok := nameContains(fileName, o.Storage.NameContains[category]); ok {
+			matches = append(matches, Match{Category: category, Criterion: criterionNameContains + ":" + substring})
+			continue
+		}
+		// sniff_override rules let the content decide, they don't take files whose content is another known format.
+		overridden := o.Storage.SniffOverride[category] && content != "" && !o.hasExtension(category, content)
+		switch {
+		case o.hasExtension(category, ext) && !overridden:
+			matches = append(matches, Match{Category: category, Criterion: criterionExtension + ":" + ext})
+		case o.hasExtension(category, content):
+			matches = append(matches, Match{Category: category, Criterion: criterionContent + ":" + content})
+		}
+	}
+	return matches
+}
+
+func nameContains(fileName string, substrings []string) (string, bool) {
+	for _, substring := range substrings {
+		if substring != "" && strings.Contains(fileName, substring) {
+			return substring, true
+		}
+	}
+	return "", false
+}
+
+// pickMatch chooses the winner out of matches, which must be in rules file order:
+//  1. categories listed in override.priority_order, in that order
+//  2. name_contains, content of sniff_override rules, extension, then content matches
+//  3. rules file order
+func (o *Operator) pickMatch(matches []Match) Match {
+	switch len(matches) {
+	case 0:
+		return Match{Category: unknown, Reason: "no rule matched"}
+	case 1:
+		matches[0].Reason = "only matching rule"
+		return matches[0]
+	}
+
+	priority := func(m Match) int {
+		if i := slices.Index(o.Storage.Priority, m.Category); i >= 0 {
+			return i
+		}
+		return len(o.Storage.Priority)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if pi, pj := priority(matches[i]), priority(matches[j]); pi != pj {
+			return pi < pj
+		}
+		return o.rank(matches[i]) < o.rank(matches[j])
+	})
+
+	best, runnerUp := matches[0], matches[1]
+	var rule string
+	switch {
+	case priority(best) != priority(runnerUp):
+		rule = "priority_order"
+	case o.rank(best) != o.rank(runnerUp):
+		rule = best.kind() + " before " + runnerUp.kind()
+	default:
+		rule = "rules file order"
+	}
+
+	losers := make([]string, 0, len(matches)-1)
+	for _, m := range matches[1:] {
+		losers = append(losers, fmt.Sprintf("%s(%s)", m.Category, m.Criterion))
+	}
+	best.Reason = fmt.Sprintf("%s over %s", rule, strings.Join(losers, ","))
+	slog.Debug("multiple rules match", "category", best.Category, "reason", best.Reason)
+	return best
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const noConflict = "no conflict"
+
+// conflictDecision is what the --on-conflict policy decided for a single file.
+// Path is where the file goes, or the path it conflicted with if it's skipped.
+type conflictDecision struct {
+	Path      string
+	Skip      bool
+	Overwrite bool
+	Duplicate bool   // skipped since Path has the same content
+	Hash      string // sha256 of the source, if it was hashed to decide
+	Reason    string
+}
+
+// resolveConflict applies the --on-conflict policy to src, whose destination dst is already taken.
+// The policies only apply to files which existed before the run, a name another source got
+// during this run is never overwritten or skipped over, src gets the next free name instead.
+// A destination the --resume log has as the copy of src is replaced whatever the policy, see alreadyDone.
+// hashes are the ones of hashCandidates, only skip-identical needs them.
+// o.mu must be held by the caller.
+func (o *Operator) resolveConflict(dst, src string, srcInfo os.FileInfo, hashes candidateHashes) (conflictDecision, error) {
+	if _, reserved := o.reserved[dst]; reserved {
+		free, err := o.nextFreePath(dst)
+		return conflictDecision{Path: free, Reason: "suffix: taken during this run"}, err
+	}
+	if resumedDst, resumed := o.resumed[src]; resumed && resumedDst == dst {
+		return conflictDecision{Path: dst, Overwrite: true, Reason: "overwrite: incomplete copy of the resumed run"}, nil
+	}
+	switch o.Flags.OnConflict {
+	case ConflictSkip:
+		return conflictDecision{Path: dst, Skip: true, Reason: "skip: destination exists"}, nil
+	case ConflictOverwrite:
+		return conflictDecision{Path: dst, Overwrite: true, Reason: "overwrite: destination exists"}, nil
+	case ConflictKeepNewer, ConflictKeepLarger:
+		existing, err := os.Stat(dst)
+		if err != nil {
+			slog.Warn("failed to stat conflicting file, falling back to suffix", "path", dst, "error", err)
+			break
+		}
+		if o.Flags.OnConflict == ConflictKeepNewer {
+			if srcInfo.ModTime().After(existing.ModTime()) {
+				return conflictDecision{Path: dst, Overwrite: true, Reason: "keep-newer: source is newer"}, nil
+			}
+			return conflictDecision{Path: dst, Skip: true, Reason: "keep-newer: destination isn't older"}, nil
+		}
+		if srcInfo.Size() > existing.Size() {
+			return conflictDecision{Path: dst, Overwrite: true, Reason: "keep-larger: source is larger"}, nil
+		}
+		return conflictDecision{Path: dst, Skip: true, Reason: "keep-larger: destination isn't smaller"}, nil
+	case ConflictSkipIdentical:
+		return o.skipIdentical(dst, hashes)
+	}
+	free, err := o.nextFreePath(dst)
+	return conflictDecision{Path: free, Reason: "suffix: renamed"}, err
+}
+
+// candidateHashes are the sha256 of a source and of the files at its destination names, see hashCandidates.
+type candidateHashes struct {
+	src      string
+	existing map[string]string // [path]sha256 of the files of the same size as the source
+}
+
+// hashCandidates hashes src and the files at dst, dst_1, dst_2, ... which have its size, for skip-identical.
+// Hashing runs without o.mu, so a large file doesn't hold up the destinations of the other workers,
+// skipIdentical checks the names again under the lock. If src can't be hashed, src is empty.
+func (o *Operator) hashCandidates(dst, src string, srcInfo os.FileInfo) (candidateHashes, error) {
+	hashes := candidateHashes{existing: make(map[string]string)}
+	candidate := dst
+	for i := 1; ; i++ {
+		o.mu.Lock()
+		_, reserved := o.reserved[candidate]
+		o.mu.Unlock()
+		info, err := os.Stat(candidate)
+		if err != nil && !os.IsNotExist(err) {
+			return candidateHashes{}, fmt.Errorf("failed to check destination: %w", err)
+		}
+		if err != nil && !reserved {
+			return hashes, nil
+		}
+		if err == nil && !reserved && info.Mode().IsRegular() && info.Size() == srcInfo.Size() {
+			if hashes.src == "" {
+				if hashes.src, err = hashFile(src); err != nil {
+					slog.Warn("failed to hash source, falling back to suffix", "path", src, "error", err)
+					return candidateHashes{}, nil
+				}
+			}
+			if hash, err := hashFile(candidate); err == nil {
+				hashes.existing[candidate] = hash
+			}
+		}
+		candidate = suffixPath(dst, i)
+	}
+}
+
+// skipIdentical walks dst, dst_1, dst_2, ... and skips the source if one of them existed before the run
+// and has the same content, otherwise it gets the first free name like with the suffix policy.
+// o.mu must be held by the caller.
+func (o *Operator) skipIdentical(dst string, hashes candidateHashes) (conflictDecision, error) {
+	if hashes.src == "" {
+		free, err := o.nextFreePath(dst)
+		return conflictDecision{Path: free, Reason: "suffix: renamed"}, err
+	}
+	candidate := dst
+	for i := 1; ; i++ {
+		taken, err := o.dstTaken(candidate)
+		if err != nil {
+			return conflictDecision{}, err
+		}
+		if !taken {
+			return conflictDecision{Path: candidate, Hash: hashes.src, Reason: "skip-identical: content differs, renamed"}, nil
+		}
+		if _, reserved := o.reserved[candidate]; !reserved && hashes.existing[candidate] == hashes.src {
+			return conflictDecision{Path: candidate, Skip: true, Duplicate: true, Hash: hashes.src,
+				Reason: fmt.Sprintf("skip-identical: same sha256 as %s", candidate)}, nil
+		}
+		candidate = suffixPath(dst, i)
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
-	"syscall"
 )
 
+// DirSize sums up the sizes of the files below path, without following symbolic links.
+// Entries which can't be read are left out, the run reports them when it gets to them.
 func DirSize(path string) (int64, error) {
 	var size int64
 	var mu sync.Mutex
@@ -18,7 +19,11 @@ func DirSize(path string) (int64, error) {
 	calculateSize = func(p string) error {
 		fileInfo, err := os.Lstat(p)
 		if err != nil {
-			return err
+			if p == path {
+				return err
+			}
+			slog.Debug("left out of the directory size", "path", p, "error", err)
+			return nil
 		}
 
 		// skip symbolic links to avoid counting them multiple times
@@ -29,7 +34,8 @@ func DirSize(path string) (int64, error) {
 		if fileInfo.IsDir() {
 			entries, err := os.ReadDir(p)
 			if err != nil {
-				return err
+				slog.Debug("left out of the directory size", "path", p, "error", err)
+				return nil
 			}
 			for _, entry := range entries {
 				if err := calculateSize(filepath.Join(p, entry.Name())); err != nil {
@@ -69,10 +75,13 @@ func ValidateDir(dirp string) error {
 	return nil
 }
 
+// dirPerm is the mode of every directory created in the destination, before the umask.
+const dirPerm = 0o755
+
 func createDirectory(path string) error {
 	if _, err := os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
-			if errCreateDirectory := os.MkdirAll(path, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY); errCreateDirectory != nil {
+			if errCreateDirectory := os.MkdirAll(path, dirPerm); errCreateDirectory != nil {
 				return errCreateDirectory
 			}
 		} else {
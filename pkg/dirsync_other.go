@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pkg
+
+// syncDir is a no-op outside of unix, directories can't be fsynced on windows.
+func syncDir(_ string) error {
+	return nil
+}
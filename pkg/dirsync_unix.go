@@ -0,0 +1,21 @@
+//go:build unix
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir fsyncs the directory dir, so names created or renamed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory to sync it:%s:%w", dir, err)
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return fmt.Errorf("failed to sync directory:%s:%w", dir, err)
+	}
+	return d.Close()
+}
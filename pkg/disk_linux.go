@@ -0,0 +1,38 @@
+//go:build linux
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// rotational reports whether fp, or its closest existing parent, is on a spinning disk.
+// The kernel tells it in /sys/dev/block/MAJOR:MINOR/queue/rotational, partitions keep it in their disk's directory.
+func rotational(fp string) bool {
+	var stat syscall.Stat_t
+	for syscall.Stat(fp, &stat) != nil {
+		parent := filepath.Dir(fp)
+		if parent == fp {
+			return false
+		}
+		fp = parent
+	}
+
+	dev := uint64(stat.Dev) //nolint:unconvert // Dev is uint32 on some architectures
+	major := ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+	minor := (dev & 0xff) | ((dev >> 12) &^ 0xff)
+	dir, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return false
+	}
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		if data, err := os.ReadFile(filepath.Join(d, "queue", "rotational")); err == nil {
+			return strings.TrimSpace(string(data)) == "1"
+		}
+	}
+	return false
+}
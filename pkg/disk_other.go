@@ -0,0 +1,8 @@
+//go:build !linux
+
+package pkg
+
+// rotational can't tell spinning disks apart outside of linux, everything is treated like an SSD.
+func rotational(_ string) bool {
+	return false
+}
@@ -4,70 +4,199 @@ import (
 	"errors"
 	"fmt"
 	"github.com/barasher/go-exiftool"
+	"log/slog"
 	"os"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 var ErrorNoCreateDate = errors.New("given file doesn't have a CreateDate field or we failed to find it")
 
-func initExifTool() (*exiftool.Exiftool, error) {
-	exifTool, err := exiftool.NewExiftool()
+const (
+	dateSourceExif     = "exif:"
+	dateSourceFilename = "filename"
+	dateSourceMtime    = "mtime"
+	dateSourceNone     = "none" // logged for files of sort rules without any date
+	exifDateLayout     = "2006:01:02 15:04:05"
+	undatedDir         = "undated"
+)
+
+// defaultDateSources is used by sort rules without date_sources.
+var defaultDateSources = []string{"exif:DateTimeOriginal", "exif:CreateDate", "exif:MediaCreateDate", dateSourceFilename}
+
+// initExifTool starts exiftool, asking only for tags if there are any.
+func initExifTool(tags []string) (*exiftool.Exiftool, error) {
+	opts := make([]func(*exiftool.Exiftool) error, 0)
+	if len(tags) > 0 {
+		opts = append(opts,
+			exiftool.Api("RequestTags="+strings.Join(tags, ",")),
+			exiftool.Api("IgnoreTags=all"),
+			exiftool.Api("FastScan=1"))
+	}
+	exifTool, err := exiftool.NewExiftool(opts...)
 	if err != nil {
 		return nil, err
 	}
 	return exifTool, nil
 }
 
-// getFileDate tries EXIF -> CreateDate and returns either month or year as string
-// periodType is "month" or "year"
-// if file doesn't have exif data return "" string
-func (o *Operator) getFileDate(fp, periodType string) (string, error) { //nolint:unused
-	f, err := os.Open(fp)
-	if err != nil {
-		return "", err
+// exif returns the pool of exiftool processes, it's started on first use with the tags of exifTags.
+func (o *Operator) exif() (*ExifPool, error) {
+	o.exifOnce.Do(func() {
+		workers := o.Flags.ExifWorkers
+		if workers <= 0 {
+			workers = defaultExifWorkers
+		}
+		o.Storage.Exif, o.exifErr = newExifPool(workers, o.exifTags())
+		if o.exifErr != nil {
+			slog.Warn("exiftool isn't available, dates are only read by the native reader", "error", o.exifErr)
+		}
+	})
+	return o.Storage.Exif, o.exifErr
+}
+
+// closeExif shuts the exiftool processes down, if they were started.
+// The next exif call starts a new pool, e.g. for another Operate on the same operator.
+func (o *Operator) closeExif() {
+	defer func() {
+		o.Storage.Exif, o.exifErr = nil, nil
+		o.exifOnce = sync.Once{}
+	}()
+	if o.Storage.Exif == nil {
+		return
 	}
-	defer func(f *os.File) {
-		err := f.Close()
+	if err := o.Storage.Exif.Close(); err != nil {
+		slog.Error("failed to close exiftool", "error", err)
+	}
+}
+
+// validDateSource reports whether source is one of exif:<Tag>, filename or mtime.
+func validDateSource(source string) bool {
+	if tag, isExif := strings.CutPrefix(source, dateSourceExif); isExif {
+		return tag != ""
+	}
+	return source == dateSourceFilename || source == dateSourceMtime
+}
+
+// nativeReadable reports whether every tag asked for by the date sources is known by readNativeDates.
+func (o *Operator) nativeReadable() bool {
+	for _, tag := range o.exifTags() {
+		if !slices.Contains(nativeDateTags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// exifFields returns the EXIF fields of fp, out of the metadata cache if prefetchMetadata got them already.
+// Otherwise the native reader is tried first, exiftool only reads the files it can't.
+// Without exiftool, those files have no fields.
+func (o *Operator) exifFields(fp string) (map[string]interface{}, error) {
+	var key string
+	if o.cache != nil {
+		info, err := os.Stat(fp)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		key = cacheKey(fp, info)
+		if tags, cached := o.cache.get(key); cached {
+			fields := make(map[string]interface{}, len(tags))
+			for tag, value := range tags {
+				fields[tag] = value
+			}
+			return fields, nil
 		}
-	}(f)
+	}
+
+	if o.nativeReadable() {
+		fields, err := readNativeDates(fp)
+		if err == nil {
+			if o.cache != nil {
+				o.cache.put(key, fields)
+			}
+			return fields, nil
+		}
+		if !errors.Is(err, ErrorUnsupportedFormat) {
+			slog.Debug("native EXIF reader failed, trying exiftool", "error", err)
+		}
+	}
 
-	var timePeriod string
-	fileInfos := o.Storage.Exif.ExtractMetadata(f.Name())
+	exif, err := o.exif()
+	if err != nil {
+		return nil, nil
+	}
+	fileInfos := exif.ExtractMetadata(fp)
 	for _, fileInfo := range fileInfos {
 		if fileInfo.Err != nil {
-			return "", fileInfo.Err
+			return nil, fileInfo.Err
 		}
-		if date, exists := fileInfo.Fields["CreateDate"]; exists {
-			timePeriod = date.(string)
+		if o.cache != nil {
+			o.cache.put(key, fileInfo.Fields)
 		}
+		return fileInfo.Fields, nil
+	}
+	return nil, nil
+}
+
+// parseExifDate parses dates like "2022:12:08 19:09:53", sub-seconds and time zones after that are ignored.
+func parseExifDate(value interface{}) (time.Time, bool) {
+	date, ok := value.(string)
+	if !ok || len(date) < len(exifDateLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(exifDateLayout, date[:len(exifDateLayout)])
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
 	}
+	return t, true
+}
 
-	if timePeriod != "" {
-		parseTime, err := func(timePeriod, periodType string) (string, error) {
-			ta, timeError := time.Parse("2006:01:02 15:04:05", timePeriod)
-			if timeError != nil {
-				return "", timeError
+// fileDate tries the date sources in order and returns the first date found and the source it came from.
+// If none of them has a date, ErrorNoCreateDate is returned.
+func (o *Operator) fileDate(fp string, sources []string) (time.Time, string, error) {
+	var fields map[string]interface{}
+	exifRead := false
+	for _, source := range sources {
+		switch {
+		case strings.HasPrefix(source, dateSourceExif):
+			if !exifRead {
+				exifRead = true
+				var err error
+				if fields, err = o.exifFields(fp); err != nil {
+					slog.Warn("failed to read EXIF data", "path", fp, "error", err)
+				}
 			}
-			switch periodType {
-			case "month":
-				yearmonth := strings.Split(ta.Format("2006-01"), "-")
-				return strings.Join(yearmonth, "/"), nil
-			case "year":
-				return ta.Format("2006"), nil
-			default:
-				return "", errors.New("no time thingy m8")
+			if t, ok := parseExifDate(fields[strings.TrimPrefix(source, dateSourceExif)]); ok {
+				return t, source, nil
 			}
-		}(timePeriod, periodType)
-		if err != nil {
-			return "", err
-		}
-		if parseTime == "" {
-			return "", fmt.Errorf("invalid periodType %s, must be 'month' or 'year'", periodType)
+		case source == dateSourceFilename:
+			// exports which lost their EXIF data often still have the date in their name.
+			if t, err := o.Pattern.Date(path.Base(fp)); err == nil {
+				return t, source, nil
+			}
+		case source == dateSourceMtime:
+			info, err := os.Stat(fp)
+			if err != nil {
+				return time.Time{}, "", err
+			}
+			return info.ModTime(), source, nil
 		}
-		return parseTime, nil
 	}
-	return "", ErrorNoCreateDate
+	return time.Time{}, "", ErrorNoCreateDate
+}
+
+// formatPeriod returns t as YEAR/MONTH or YEAR directories, periodType is "month" or "year".
+func formatPeriod(t time.Time, periodType string) (string, error) {
+	switch periodType {
+	case "month":
+		yearmonth := strings.Split(t.Format("2006-01"), "-")
+		return strings.Join(yearmonth, "/"), nil
+	case "year":
+		return t.Format("2006"), nil
+	default:
+		return "", fmt.Errorf("invalid periodType %s, must be 'month' or 'year'", periodType)
+	}
 }
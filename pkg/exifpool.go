@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/barasher/go-exiftool"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// defaultExifWorkers is the default size of the exiftool pool.
+var defaultExifWorkers = max(1, runtime.NumCPU()/2)
+
+// exifRequest is a single ExtractMetadata call waiting for a free exiftool process.
+type exifRequest struct {
+	files []string
+	reply chan []exiftool.FileMetadata
+}
+
+// ExifPool owns several exiftool processes, each one is used by a single worker goroutine,
+// requests reach the workers through a channel. A crashed process is restarted by its worker.
+type ExifPool struct {
+	tags      []string
+	requests  chan exifRequest
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	closeErrs []error
+	closeOnce sync.Once
+	closeMu   sync.RWMutex // held for writing by Close, so no request is sent on the closed channel
+	closed    bool
+}
+
+var ErrorExifClosed = errors.New("exiftool pool is closed")
+
+// newExifPool starts n exiftool processes asking for tags.
+func newExifPool(n int, tags []string) (*ExifPool, error) {
+	p := &ExifPool{tags: tags, requests: make(chan exifRequest)}
+	for i := 0; i < max(1, n); i++ {
+		et, err := initExifTool(tags)
+		if err != nil {
+			p.Close() //nolint:errcheck // the start error is the interesting one
+			return nil, err
+		}
+		p.wg.Add(1)
+		go p.worker(et)
+	}
+	return p, nil
+}
+
+// ExtractMetadata extracts the metadata of files on the next free exiftool process.
+// Once the pool is closed, every file fails with ErrorExifClosed.
+func (p *ExifPool) ExtractMetadata(files ...string) []exiftool.FileMetadata {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return failedMetadata(files, ErrorExifClosed)
+	}
+	reply := make(chan []exiftool.FileMetadata, 1)
+	p.requests <- exifRequest{files: files, reply: reply}
+	return <-reply
+}
+
+func (p *ExifPool) worker(et *exiftool.Exiftool) {
+	defer p.wg.Done()
+	for req := range p.requests {
+		var results []exiftool.FileMetadata
+		if et != nil {
+			results = et.ExtractMetadata(req.files...)
+		}
+		if et == nil || crashed(results) {
+			slog.Warn("exiftool process is gone, restarting it")
+			if et != nil {
+				_ = et.Close()
+			}
+			var err error
+			if et, err = initExifTool(p.tags); err != nil {
+				slog.Error("failed to restart exiftool", "error", err)
+				req.reply <- failedMetadata(req.files, err)
+				continue
+			}
+			results = et.ExtractMetadata(req.files...)
+		}
+		req.reply <- results
+	}
+
+	if et != nil {
+		if err := et.Close(); err != nil {
+			p.mu.Lock()
+			p.closeErrs = append(p.closeErrs, err)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// crashed reports whether results failed because the exiftool process died, instead of a single file being broken.
+func crashed(results []exiftool.FileMetadata) bool {
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		if errors.Is(result.Err, io.ErrClosedPipe) || errors.Is(result.Err, syscall.EPIPE) ||
+			strings.Contains(result.Err.Error(), "error while reading stdMergedOut") {
+			return true
+		}
+	}
+	return false
+}
+
+func failedMetadata(files []string, err error) []exiftool.FileMetadata {
+	results := make([]exiftool.FileMetadata, len(files))
+	for i, file := range files {
+		results[i] = exiftool.FileMetadata{File: file, Err: err}
+	}
+	return results
+}
+
+// Close stops every worker once the pending requests are done and shuts their exiftool processes down.
+func (p *ExifPool) Close() error {
+	p.closeOnce.Do(func() {
+		p.closeMu.Lock()
+		p.closed = true
+		close(p.requests)
+		p.closeMu.Unlock()
+	})
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.closeErrs) > 0 {
+		return fmt.Errorf("failed to close exiftool: %w", errors.Join(p.closeErrs...))
+	}
+	return nil
+}
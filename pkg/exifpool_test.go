@@ -0,0 +1,25 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_ExifPool_closed(t *testing.T) {
+	p := &ExifPool{requests: make(chan exifRequest)}
+	require.NoError(t, p.Close())
+	results := p.ExtractMetadata("a.jpg", "b.jpg")
+	require.Len(t, results, 2)
+	assert.ErrorIs(t, results[0].Err, ErrorExifClosed, "a closed pool fails the files instead of panicking")
+	require.NoError(t, p.Close(), "closing twice is fine")
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Storage.Exif = &ExifPool{requests: make(chan exifRequest)}
+	o.exifOnce.Do(func() {})
+	o.closeExif()
+	assert.Nil(t, o.Storage.Exif, "the next run starts a new pool")
+	o.exifOnce.Do(func() { o.exifErr = ErrorExifClosed })
+	assert.ErrorIs(t, o.exifErr, ErrorExifClosed, "the pool is started again on first use")
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// FileError is why a single file or directory failed during the run.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns the failures of the run, in the order they happened.
+func (o *Operator) Errors() []FileError {
+	o.errMu.Lock()
+	defer o.errMu.Unlock()
+	return append([]FileError(nil), o.errs...)
+}
+
+// failDir records that dirpath couldn't be read, its files are left out and the walk goes on.
+// The pre-scan and the traversal both run into it, it's recorded once.
+func (o *Operator) failDir(dirpath string, err error) {
+	o.errMu.Lock()
+	failed := o.failedDirs[dirpath]
+	if o.failedDirs == nil {
+		o.failedDirs = make(map[string]bool)
+	}
+	o.failedDirs[dirpath] = true
+	o.errMu.Unlock()
+	if !failed {
+		o.fail(LogEntry{Source: dirpath}, fmt.Errorf("failed to read directory: %w", err))
+	}
+}
+
+// failedEntry is the log entry of a file which failed before Copy could fill in the rest.
+func (o *Operator) failedEntry(fp string, match Match) LogEntry {
+	return LogEntry{
+		Source:     fp,
+		FileName:   path.Base(fp),
+		Category:   match.Category,
+		Rule:       match.Criterion,
+		Reason:     match.Reason,
+		DateSource: match.DateSource,
+		Content:    match.Content,
+		Size:       fileSize(fp),
+	}
+}
+
+// fail records that the file of entry failed with err: it's logged, written into the CSV log as a FAILED row,
+// kept for the summary and counted for the exit code. With --fail-fast the rest of the run is cancelled.
+func (o *Operator) fail(entry LogEntry, err error) {
+	slog.Error("failed", "path", entry.Source, "error", err)
+	o.failures.Add(1)
+	o.errMu.Lock()
+	o.errs = append(o.errs, FileError{Path: entry.Source, Err: err})
+	o.errMu.Unlock()
+
+	entry.Status = statusFailed
+	entry.Reason = strings.TrimPrefix(strings.Join([]string{entry.Reason, err.Error()}, "; "), "; ")
+	o.logResult(entry)
+
+	if o.Flags.FailFast && o.cancel != nil {
+		o.cancel()
+	}
+}
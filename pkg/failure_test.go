@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_fail(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		require.NoError(t, os.Symlink(path.Join(src, "missing"), path.Join(src, name)))
+	}
+
+	for _, failFast := range []bool{false, true} {
+		o, err := GetNewOperator()
+		require.NoError(t, err)
+		o.Flags = Flags{SrcPath: src, DstPath: t.TempDir(), FailFast: failFast}
+		o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+		ctx, cancel := context.WithCancel(context.Background())
+		o.cancel = cancel
+
+		_, err = o.ProcessDir(ctx, src)
+		require.NoError(t, err)
+		errs := o.Errors()
+		if failFast {
+			require.Len(t, errs, 1)
+			assert.Error(t, ctx.Err(), "the run is cancelled")
+		} else {
+			require.Len(t, errs, 2)
+			assert.NoError(t, ctx.Err())
+		}
+		assert.Equal(t, path.Join(src, "a.jpg"), errs[0].Path)
+		assert.ErrorIs(t, errs[0], os.ErrNotExist)
+		assert.Equal(t, int64(len(errs)), o.Failures())
+		cancel()
+	}
+}
+
+func Test_failDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	locked := path.Join(src, "locked")
+	require.NoError(t, os.Mkdir(locked, 0o755))
+	require.NoError(t, os.WriteFile(path.Join(locked, "b.jpg"), []byte("jpg"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(src, "a.jpg"), []byte("jpg"), 0o644))
+	require.NoError(t, os.Chmod(locked, 0))
+	t.Cleanup(func() { _ = os.Chmod(locked, 0o755) })
+
+	size, err := DirSize(src)
+	require.NoError(t, err, "unreadable entries are left out")
+	assert.Equal(t, int64(3), size)
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst}
+	rules := []Rule{{Category: "images", Extensions: []string{"jpg"}}}
+	o.BuildStorageMaps(&Config{Rules: rules})
+	require.NoError(t, o.CreateSubdirs(dst, rules))
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+
+	errs := o.Errors()
+	require.Len(t, errs, 1, "the pre-scan and the traversal record the directory once")
+	assert.Equal(t, locked, errs[0].Path)
+	assert.ErrorIs(t, errs[0], os.ErrPermission)
+	assert.FileExists(t, path.Join(dst, "images", "a.jpg"))
+}
+
+func Test_fail_async(t *testing.T) {
+	src := t.TempDir()
+	for i := range 8 {
+		require.NoError(t, os.WriteFile(path.Join(src, fmt.Sprintf("%d.jpg", i)), []byte("jpg"), 0o644))
+	}
+
+	for _, failFast := range []bool{false, true} {
+		// the category directory is never created, every copy of the workers fails.
+		o, err := GetNewOperator()
+		require.NoError(t, err)
+		o.Flags = Flags{SrcPath: src, DstPath: t.TempDir(), Async: true, Workers: 2, FailFast: failFast}
+		o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+		_, err = o.Operate(context.Background())
+		require.NoError(t, err)
+
+		errs := o.Errors()
+		if failFast {
+			assert.NotEmpty(t, errs)
+			assert.LessOrEqual(t, len(errs), 2, "only the copies already running when the first one failed fail too")
+		} else {
+			assert.Len(t, errs, 8, "every worker records its failure")
+		}
+		for _, err := range errs {
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		}
+		assert.Equal(t, int64(len(errs)), o.Failures())
+		statuses, _ := o.report.counts()
+		assert.Equal(t, len(errs), statuses[statusFailed], "every failure is a FAILED row")
+	}
+}
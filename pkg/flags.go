@@ -5,68 +5,221 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 )
 
+const (
+	OrgDirCmd  = "org-dir"
+	SortImgCmd = "sort-img"
+	UndoCmd    = "undo"
+)
+
+const (
+	ModeCopy     = "copy"
+	ModeMove     = "move"
+	ModeHardlink = "hardlink"
+	ModeReflink  = "reflink"
+)
+
+var modes = []string{ModeCopy, ModeMove, ModeHardlink, ModeReflink}
+
+const (
+	ConflictSuffix        = "suffix"
+	ConflictSkipIdentical = "skip-identical"
+	ConflictSkip          = "skip"
+	ConflictOverwrite     = "overwrite"
+	ConflictKeepNewer     = "keep-newer"
+	ConflictKeepLarger    = "keep-larger"
+)
+
+var conflictPolicies = []string{ConflictSuffix, ConflictSkipIdentical, ConflictSkip, ConflictOverwrite, ConflictKeepNewer, ConflictKeepLarger}
+
+// Flags are the options of a run, the json names are the ones the --report config shows.
 type Flags struct {
-	SrcPath  string
-	DstPath  string
-	RulePath string
-	LogPath  string
-	DryRun   bool
-	Async    bool
-	Verbose  bool
-	Pattern  string
+	SubCommand   string   `json:"subcommand"`
+	SrcPath      string   `json:"src"`
+	DstPath      string   `json:"dst"`
+	RulePath     string   `json:"rules,omitempty"`
+	LogPath      string   `json:"log"`
+	LogAppend    bool     `json:"log_append"` // add the rows to an existing log instead of truncating it
+	ReportPath   string   `json:"report"`     // JSON report of the run, see WriteReport
+	DryRun       bool     `json:"dry_run"`
+	Async        bool     `json:"async"`
+	Verbose      bool     `json:"verbose"`
+	Pattern      string   `json:"pattern"`
+	Sort         string   `json:"sort,omitempty"`         // sort-img only: month or year
+	DateSources  []string `json:"date_sources,omitempty"` // sort-img only: date sources tried in order
+	Validate     bool     `json:"validate"`
+	Mode         string   `json:"mode"`           // copy, move, hardlink or reflink
+	OnConflict   string   `json:"on_conflict"`    // what to do when the destination name is taken, see conflictPolicies
+	Resume       string   `json:"resume"`         // log of an interrupted run to continue
+	Preserve     []string `json:"preserve"`       // attributes copied from the source, see preserveAttributes
+	ExifCache    string   `json:"exif_cache"`     // file keeping EXIF dates between runs, empty disables it
+	ExifWorkers  int      `json:"exif_workers"`   // number of exiftool processes
+	Sniff        bool     `json:"sniff"`          // classify by file header too, see sniffFile
+	Workers      int      `json:"workers"`        // async workers shared by the whole traversal, 0 picks them by disk type
+	MaxOpenFiles int      `json:"max_open_files"` // files open at once by copies, 0 uses half of the process limit
+	FailFast     bool     `json:"fail_fast"`      // stop at the first failed file
+	UndoRun      string   `json:"-"`              // undo only: runId of the run to revert, see UndoAllRuns
 }
 
-func GetFlags(args []string) Flags {
-	srcPath := flag.String("src", "./testDir", "Source directory path")
-	dstPath := flag.String("dst", "", "Destination directory path")
-	rulePath := flag.String("rules", "./rules.yaml", "output category rules")
-	log := flag.String("log", "", "Log path")
-	dryRun := flag.Bool("dry-run", false, "Dry-run option")
-	async := flag.Bool("async", false, "Faster async option, uses goroutines")
-	verbose := flag.Bool("verbose", false, "Set to debug mode")
-	pattern := flag.String("pattern", "", "image file pattern, e.g.: IMG_YEARMONTHDAY_HOURMINUTESECOND.ext, IMG_20220830_195427.jpg")
-	// TODO: implement me: validate := flag.Bool("validate", false, "Enable SHA256 validation after copy operation")
-
-	flag.Parse()
-
-	if *srcPath == "" {
+// bindCommonFlags registers the flags every subcommand understands.
+func bindCommonFlags(fs *flag.FlagSet, f *Flags) {
+	fs.StringVar(&f.SrcPath, "src", "./testDir", "Source directory path")
+	fs.StringVar(&f.DstPath, "dst", "", "Destination directory path")
+	fs.StringVar(&f.LogPath, "log", "", "Log path")
+	fs.BoolVar(&f.LogAppend, "log-append", false, "append to the log instead of truncating it, the runId column tells the runs apart")
+	fs.StringVar(&f.ReportPath, "report", "", "write a JSON report of the run: counts and bytes per category and extension, "+
+		"duplicates, skipped files, errors, date sources, phase timings and the effective configuration")
+	fs.BoolVar(&f.DryRun, "dry-run", false, "Dry-run option")
+	fs.BoolVar(&f.Async, "async", false, "Faster async option, uses goroutines")
+	fs.BoolVar(&f.Verbose, "verbose", false, "Set to debug mode")
+	fs.StringVar(&f.Pattern, "pattern", "", "image file pattern, e.g.: IMG_YEARMONTHDAY_HOURMINUTESECOND.ext, IMG_20220830_195427.jpg. "+
+		"tokens: YEAR MONTH DAY HOUR MINUTE SECOND, 'ext' for any extension and '*' for anything. "+
+		"used by sort rules when a file has no EXIF date")
+	fs.BoolVar(&f.Validate, "validate", false, "Enable SHA256 validation after copy operation")
+	fs.StringVar(&f.Mode, "mode", ModeCopy, fmt.Sprintf("how files get to the destination, one of %v. "+
+		"move renames on the same filesystem, otherwise copies, verifies and removes the source. "+
+		"hardlink and reflink fall back to copy when linking isn't possible", modes))
+	fs.Func("preserve", fmt.Sprintf("comma separated attributes to keep on copies, out of %v or 'all'. owner needs root", preserveAttributes),
+		func(value string) error {
+			if value == "all" {
+				f.Preserve = preserveAttributes
+				return nil
+			}
+			for _, attribute := range strings.Split(value, ",") {
+				if !slices.Contains(preserveAttributes, attribute) {
+					return fmt.Errorf("unknown attribute %q, must be one of %v or 'all'", attribute, preserveAttributes)
+				}
+				f.Preserve = append(f.Preserve, attribute)
+			}
+			return nil
+		})
+	fs.StringVar(&f.ExifCache, "exif-cache", defaultCachePath(), "file keeping EXIF dates between runs, keyed by path, size and mtime. empty disables it")
+	fs.IntVar(&f.ExifWorkers, "exif-workers", defaultExifWorkers, "number of exiftool processes extracting dates in parallel")
+	fs.BoolVar(&f.Sniff, "sniff", false, "read file headers to classify extensionless and mislabeled files, "+
+		"disagreements with the extension are reported. rules with sniff_override let the content win")
+	fs.IntVar(&f.Workers, "workers", 0, "number of files processed at once with --async, shared by all directories. "+
+		"0 uses 2 if the source or destination is a spinning disk and the CPU count, at least 4, otherwise")
+	fs.IntVar(&f.MaxOpenFiles, "max-open-files", 0, "limit of files copies hold open at once, each copy holds 2. 0 uses half of the process limit")
+	fs.BoolVar(&f.FailFast, "fail-fast", false, "stop at the first failed file instead of carrying on with the others")
+	fs.StringVar(&f.Resume, "resume", "", "log of an interrupted run: skips files it copied successfully and appends to it")
+	fs.StringVar(&f.OnConflict, "on-conflict", ConflictSuffix, fmt.Sprintf("what to do when the destination name is taken, one of %v. "+
+		"suffix adds _N to the name, skip-identical only skips files with the same sha256", conflictPolicies))
+}
+
+// parseFlags parses args into f and applies the defaults shared by every subcommand.
+func parseFlags(fs *flag.FlagSet, args []string, f *Flags) Flags {
+	// flag.ExitOnError makes Parse exit on its own.
+	_ = fs.Parse(args)
+
+	if f.SrcPath == "" {
 		fmt.Println("source path must be provided")
-		flag.Usage()
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if !slices.Contains(modes, f.Mode) {
+		fmt.Printf("invalid mode %q, must be one of %v\n", f.Mode, modes)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if !slices.Contains(conflictPolicies, f.OnConflict) {
+		fmt.Printf("invalid on-conflict policy %q, must be one of %v\n", f.OnConflict, conflictPolicies)
+		fs.Usage()
 		os.Exit(1)
 	}
 
-	if *dstPath == "" {
-		*dstPath = strings.Join([]string{strings.TrimSuffix(*srcPath, "/"), "_cp"}, "")
-		slog.Warn("destination path is not set by user", "auto-set destination path as", *dstPath)
+	if f.Resume != "" {
+		if f.LogPath != "" && f.LogPath != f.Resume {
+			fmt.Println("--resume appends to the log it resumes, --log must be empty or the same file")
+			fs.Usage()
+			os.Exit(1)
+		}
+		f.LogPath = f.Resume
 	}
 
-	if *verbose {
+	if f.DstPath == "" {
+		f.DstPath = strings.Join([]string{strings.TrimSuffix(f.SrcPath, "/"), "_cp"}, "")
+		slog.Warn("destination path is not set by user", "auto-set destination path as", f.DstPath)
+	}
+
+	if f.Verbose {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
+	return *f
+}
+
+// GetFlags parses the flags of the org-dir subcommand.
+func GetFlags(args []string) Flags {
+	f := Flags{SubCommand: OrgDirCmd}
+	fs := flag.NewFlagSet(OrgDirCmd, flag.ExitOnError)
+	bindCommonFlags(fs, &f)
+	fs.StringVar(&f.RulePath, "rules", "./rules.yaml", "output category rules")
 
-	if *rulePath == "" {
+	parseFlags(fs, args, &f)
+	if f.RulePath == "" {
 		slog.Warn("path for rules file is empty, going to use default settings from 'rules.yaml'")
-		*rulePath = "./rules.yaml"
+		f.RulePath = "./rules.yaml"
+	}
+	return f
+}
+
+// GetSortImgFlags parses the flags of the sort-img subcommand.
+// sort-img doesn't read a rules file, images and videos are sorted with MediaRules.
+func GetSortImgFlags(args []string) Flags {
+	f := Flags{SubCommand: SortImgCmd}
+	fs := flag.NewFlagSet(SortImgCmd, flag.ExitOnError)
+	bindCommonFlags(fs, &f)
+	fs.StringVar(&f.Sort, "sort", "month", "date tree depth: 'month' for YEAR/MONTH or 'year' for YEAR directories")
+	dateSources := fs.String("date-sources", strings.Join(defaultDateSources, ","),
+		"comma separated date sources tried in order: exif:<Tag>, filename (see --pattern) or mtime. files without a date go to 'undated'")
+
+	parseFlags(fs, args, &f)
+	f.DateSources = strings.Split(*dateSources, ",")
+	for _, source := range f.DateSources {
+		if !validDateSource(source) {
+			fmt.Printf("invalid date source %q, must be exif:<Tag>, filename or mtime\n", source)
+			fs.Usage()
+			os.Exit(1)
+		}
 	}
-	return Flags{
-		SrcPath:  *srcPath,
-		DstPath:  *dstPath,
-		LogPath:  *log,
-		DryRun:   *dryRun,
-		Async:    *async,
-		Verbose:  *verbose,
-		RulePath: *rulePath,
-		Pattern:  *pattern,
+	if f.Sort != "month" && f.Sort != "year" {
+		fmt.Printf("invalid sort value %q, must be 'month' or 'year'\n", f.Sort)
+		fs.Usage()
+		os.Exit(1)
 	}
-	//TODO: separate img-sort and org-dir subcommand flag functions or structs. (find a better design method)
+	return f
+}
+
+// GetUndoFlags parses the flags of the undo subcommand, it needs the log of the run to revert.
+func GetUndoFlags(args []string) Flags {
+	f := Flags{SubCommand: UndoCmd}
+	fs := flag.NewFlagSet(UndoCmd, flag.ExitOnError)
+	fs.StringVar(&f.LogPath, "log", "", "log of the run to undo")
+	fs.BoolVar(&f.DryRun, "dry-run", false, "only print what would be removed or moved back")
+	fs.StringVar(&f.RulePath, "rules", "./rules.yaml", "rules of the run to undo, their category directories left empty are removed")
+	fs.StringVar(&f.UndoRun, "run", "", fmt.Sprintf("runId of the run to undo, empty for the last run of the log, '%s' for every run", UndoAllRuns))
+	fs.BoolVar(&f.Verbose, "verbose", false, "Set to debug mode")
+	_ = fs.Parse(args)
+
+	if f.LogPath == "" {
+		fmt.Println("log path must be provided")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if f.Verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	return f
 }
 
 func GetSubCommand() string {
 	if len(os.Args) < 2 {
-		fmt.Println("expected 'org-dir' or 'sort-img' subcommand")
+		fmt.Println("expected 'org-dir', 'sort-img' or 'undo' subcommand")
+		os.Exit(1)
 	}
 	return os.Args[1]
 }
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pkg
+
+// openFilesLimit returns the default limit of open files of the C runtime on windows.
+func openFilesLimit() int {
+	return 512
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package pkg
+
+import "syscall"
+
+// openFilesLimit returns the soft limit of open files of the process.
+func openFilesLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 1024
+	}
+	return int(min(limit.Cur, 1<<16))
+}
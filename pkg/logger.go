@@ -1,17 +1,45 @@
 package pkg
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 )
 
-// CSVLogger writes log entries into a CSV file with three columns:
-// sourceFileName, destinationFileName, SUCCESS/FAILURE.
+var ErrorLogColumns = errors.New("log has columns this version doesn't write, can't append to it")
+
+// statuses of the rows of the CSV log.
+const (
+	statusSuccess     = "SUCCESS"
+	statusSkipped     = "SKIPPED"
+	statusDuplicate   = "DUPLICATE" // skipped, the destination has a file with the same sha256
+	statusFailed      = "FAILED"
+	statusInterrupted = "INTERRUPTED"
+	statusPlanned     = "PLANNED" // dry-run only, never written into the log
+	statusFailure     = "FAILURE" // FAILED in logs of older versions
+)
+
+// logColumns is the header of the CSV log, in the order of LogEntry.record.
+// New columns go at the end, so logs of older versions can be appended to, see NewCSVLogger.
+var logColumns = []string{"sourceFilePath", "destinationFilePath", "fileName", "status", "category", "rule", "reason",
+	"sha256", "operation", "conflict", "size", "modTime", "dateSource", "content", "timestamp", "runId"}
+
+// summaryColumns is the header of the summary file next to the CSV log, see writeLogSummary.
+var summaryColumns = []string{"runId", "started", "finished", "runtime", "status", "succeeded", "skipped", "duplicates",
+	"failed", "interrupted", "bytes", "unprocessed"}
+
+// CSVLogger writes log entries into a CSV file with the columns of logColumns.
 type CSVLogger struct {
 	mu     sync.Mutex
 	writer *csv.Writer
@@ -19,18 +47,57 @@ type CSVLogger struct {
 }
 
 // NewCSVLogger creates or truncates a CSV file and writes the header row.
-func NewCSVLogger(path string) (*CSVLogger, error) {
+// With appendTo, an existing file is continued instead, the header is only written into an empty file.
+// An existing header must be logColumns or a start of it, as written by older versions. Such a header is
+// widened to logColumns first, so the newer columns of the appended rows are read back too.
+func NewCSVLogger(path string, appendTo bool) (*CSVLogger, error) {
+	return newCSVFile(path, appendTo, logColumns)
+}
+
+// newCSVFile opens the CSV file at path for writing rows with the given columns, see NewCSVLogger.
+func newCSVFile(path string, appendTo bool, columns []string) (*CSVLogger, error) {
 	if path == "" {
 		return nil, nil
 	}
-	f, err := os.Create(path)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		// readable too, the header of an existing file is checked.
+		flags = os.O_CREATE | os.O_RDWR | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o666)
 	if err != nil {
 		return nil, err
 	}
 	w := csv.NewWriter(f)
 
+	info, err := f.Stat()
+	if err != nil {
+		err2 := f.Close()
+		return nil, fmt.Errorf("%w,%w", err, err2)
+	}
+	if info.Size() > 0 {
+		header, err := csv.NewReader(f).Read()
+		if err == nil && (len(header) > len(columns) || !slices.Equal(header, columns[:len(header)])) {
+			err = fmt.Errorf("%w: %s has the columns %v", ErrorLogColumns, path, header)
+		}
+		if err != nil {
+			err2 := f.Close()
+			return nil, fmt.Errorf("%w,%w", err, err2)
+		}
+		if len(header) == len(columns) {
+			return &CSVLogger{writer: w, file: f}, nil
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		if err := widenHeader(path, columns); err != nil {
+			return nil, err
+		}
+		return newCSVFile(path, appendTo, columns)
+	}
+
 	// header
-	if err := w.Write([]string{"sourceFilePath", "destinationFilePath", "fileName", "status"}); err != nil {
+	if err := w.Write(columns); err != nil {
 		err2 := f.Close()
 		return nil, fmt.Errorf("%w,%w", err, err2)
 	}
@@ -38,11 +105,87 @@ func NewCSVLogger(path string) (*CSVLogger, error) {
 	return &CSVLogger{writer: w, file: f}, nil
 }
 
+// widenHeader replaces the header of the CSV file at path with columns, which its header is a start of.
+// The rows are kept as they are, their newer columns are simply empty. The file is replaced by a rename,
+// so a crash leaves either the old or the new file.
+func widenHeader(path string, columns []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	_, rows, _ := bytes.Cut(data, []byte("\n"))
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	w.Flush()
+	b.Write(rows)
+
+	tmp, err := createTemp(path)
+	if err != nil {
+		return fmt.Errorf("failed to widen header of %s: %w", path, err)
+	}
+	_, err = tmp.Write(b.Bytes())
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to widen header of %s: %w", path, err)
+	}
+	slog.Info("widened the header of an older log", "path", path)
+	return nil
+}
+
+// LogEntry is a single row of the CSV log.
+// Rule is the criterion of the rule which chose Category, Reason tells why it won.
+type LogEntry struct {
+	Status      string
+	Source      string
+	Destination string
+	FileName    string
+	Category    string
+	Rule        string
+	Reason      string
+	Hash        string // sha256 of the source, set with --validate or if skip-identical hashed it
+	Operation   string // copy, move, hardlink or reflink, tells undo how to revert the row
+	Conflict    string // decision of the --on-conflict policy
+	Size        int64  // size of the source in bytes
+	ModTime     string // mtime of the written destination, lets undo notice later changes
+	DateSource  string // date source which dated the file for sort, "none" if it went to undated
+	Content     string // format sniffed out of the file header with --sniff
+	Timestamp   string // when the row was logged, set by logResult
+	RunID       string // run which logged the row, set by logResult
+}
+
+func (e LogEntry) record() []string {
+	size := ""
+	if e.Size > 0 {
+		size = strconv.FormatInt(e.Size, 10)
+	}
+	return []string{e.Source, e.Destination, e.FileName, e.Status, e.Category, e.Rule, e.Reason,
+		e.Hash, e.Operation, e.Conflict, size, e.ModTime, e.DateSource, e.Content, e.Timestamp, e.RunID}
+}
+
 // Log writes single entry into the CSV file.
-func (l *CSVLogger) Log(status, source, fileName, destination string) error {
+func (l *CSVLogger) Log(e LogEntry) error {
+	return l.write(e.record())
+}
+
+func (l *CSVLogger) write(record []string) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	record := []string{source, destination, fileName, status}
 	if err := l.writer.Write(record); err != nil {
 		return err
 	}
@@ -57,19 +200,127 @@ func (l *CSVLogger) Close() error {
 	return l.file.Close()
 }
 
+// logResult writes e into the CSV log, if the user asked for one, and counts it for the report and the progress.
+func (o *Operator) logResult(e LogEntry) {
+	o.report.add(e, o.fileExtension(e.Source))
+	o.progress.count(e)
+	o.writeLog(e)
+}
+
+// writeLog stamps e with the time and run ID and writes it into the CSV log, if the user asked for one.
+func (o *Operator) writeLog(e LogEntry) {
+	if o.CsvHandler == nil {
+		return
+	}
+	e.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	e.RunID = o.RunID
+	if err := o.CsvHandler.Log(e); err != nil {
+		slog.Error("failure-log", "error", err.Error())
+	}
+}
+
+// newRunID returns an ID for the run, its start time and a random part, e.g. 20240131T101500Z-3f9a1c2e.
+func newRunID() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(random)
+}
+
+// summaryPath is the file the run summaries of the log at logPath are appended to, e.g. run.summary.csv for run.csv.
+func summaryPath(logPath string) string {
+	return strings.TrimSuffix(logPath, ".csv") + ".summary.csv"
+}
+
+// writeLogSummary appends a row summing up the run to the summary file of the CSV log.
+func (o *Operator) writeLogSummary(startTime time.Time) error {
+	summary, err := newCSVFile(summaryPath(o.Flags.LogPath), true, summaryColumns)
+	if err != nil {
+		return err
+	}
+	finished := time.Now()
+	counts, bytes := o.report.counts()
+	count := func(statuses ...string) string {
+		n := 0
+		for _, status := range statuses {
+			n += counts[status]
+		}
+		return strconv.Itoa(n)
+	}
+	err = summary.write([]string{o.RunID, startTime.UTC().Format(time.RFC3339Nano), finished.UTC().Format(time.RFC3339Nano),
+		finished.Sub(startTime).String(), o.runStatus(), count(statusSuccess), count(statusSkipped), count(statusDuplicate),
+		count(statusFailed), count(statusInterrupted), strconv.FormatInt(bytes, 10), strconv.Itoa(len(o.Storage.Unprocessed))})
+	return errors.Join(err, summary.Close())
+}
+
+// runStatus sums up how the run ended: completed, failed or interrupted.
+func (o *Operator) runStatus() string {
+	switch {
+	case o.Interrupted():
+		return "interrupted"
+	case o.Failures() > 0:
+		return "failed"
+	}
+	return "completed"
+}
+
 func ResultLog(extensions int, o *Operator, startTime time.Time) {
 	slog.Debug("", "unique extension count", extensions)
 	slog.Debug("", "sub-dir count", o.SubDirCount)
 	slog.Debug("", "skipped file count", len(o.Storage.Unprocessed))
+	if o.ResumedCount > 0 {
+		slog.Info("", "already copied by resumed run", o.ResumedCount)
+	}
+	if mismatches := o.mismatches.Load(); mismatches > 0 {
+		slog.Warn("", "extension and content disagree", mismatches)
+	}
+	if failures := o.Failures(); failures > 0 {
+		slog.Error("", "failed file count", failures)
+		for _, e := range o.Errors() {
+			slog.Error("", "failed", e.Path, "error", e.Err)
+		}
+		if o.Flags.FailFast {
+			slog.Warn("--fail-fast stopped the run at the first failure, the remaining files weren't processed")
+		}
+	}
 	if len(o.Storage.Unprocessed) > 0 {
 		for _, unprocessedFileName := range o.Storage.Unprocessed {
 			slog.Warn("", "skipped", unprocessedFileName)
 		}
 	}
+	if o.Flags.DryRun {
+		printPlanSummary(o)
+	}
+	if o.Interrupted() {
+		slog.Warn("interrupted, the remaining files weren't processed. continue with --resume and the log of this run")
+	}
 	slog.Info("", "total runtime", time.Since(startTime))
 	if o.CsvHandler != nil {
-		if err := o.CsvHandler.Log(time.Since(startTime).String(), "skipped file count", "total runtime", strconv.Itoa(len(o.Storage.Unprocessed))); err != nil {
+		if err := o.writeLogSummary(startTime); err != nil {
 			slog.Error("failure-log", "error", err.Error())
 		}
 	}
 }
+
+// printPlanSummary prints how many files and bytes dry-run planned to copy into each category.
+func printPlanSummary(o *Operator) {
+	categories := make([]string, 0, len(o.plan))
+	for category := range o.plan {
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+
+	var files int
+	var bytes int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "category\tfiles\tbytes\t")
+	for _, category := range categories {
+		stat := o.plan[category]
+		fmt.Fprintf(w, "%s\t%d\t%d\t\n", category, stat.Files, stat.Bytes)
+		files += stat.Files
+		bytes += stat.Bytes
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t\n", files, bytes)
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to print dry-run summary", "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_NewCSVLogger_append(t *testing.T) {
+	dir := t.TempDir()
+	older := path.Join(dir, "older.csv")
+	require.NoError(t, os.WriteFile(older, []byte(strings.Join(logColumns[:4], ",")+"\nold.jpg,/dst/old.jpg,old.jpg,SUCCESS\n"), 0o644))
+	l, err := NewCSVLogger(older, true)
+	require.NoError(t, err, "logs of older versions have a start of the columns")
+	require.NoError(t, l.Log(LogEntry{Status: statusSuccess, Source: "a.jpg", Size: 3, RunID: "run"}))
+	require.NoError(t, l.Close())
+	rows, err := readLog(older)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "old.jpg", rows[0]["sourceFilePath"])
+	assert.Equal(t, "", rows[0]["runId"], "rows of older versions leave the newer columns empty")
+	assert.Equal(t, "a.jpg", rows[1]["sourceFilePath"])
+	assert.Equal(t, "run", rows[1]["runId"], "the header was widened, the newer columns are read back")
+	assert.Equal(t, "3", rows[1]["size"])
+
+	other := path.Join(dir, "other.csv")
+	require.NoError(t, os.WriteFile(other, []byte("name,value\n"), 0o644))
+	_, err = NewCSVLogger(other, true)
+	require.ErrorIs(t, err, ErrorLogColumns)
+}
+
+func Test_logRows(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{"a.jpg": "jpg", "empty.jpg": ""} {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte(content), 0o644))
+	}
+	require.NoError(t, os.Mkdir(path.Join(dst, "images"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "a.jpg"), []byte("jpg"), 0o644))
+	logPath := path.Join(t.TempDir(), "run.csv")
+
+	for run := range 2 {
+		o, err := GetNewOperator()
+		require.NoError(t, err)
+		o.Flags = Flags{SrcPath: src, DstPath: dst, LogPath: logPath, OnConflict: ConflictSkipIdentical}
+		o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+		o.CsvHandler, err = NewCSVLogger(logPath, run > 0)
+		require.NoError(t, err)
+		_, err = o.Operate(context.Background())
+		require.NoError(t, err)
+		ResultLog(0, o, time.Now())
+		require.NoError(t, o.CsvHandler.Close())
+
+		rows, err := readLog(logPath)
+		require.NoError(t, err)
+		require.Len(t, rows, 2*(run+1), "every file gets a row, runs are appended")
+		for _, row := range rows[2*run:] {
+			assert.Equal(t, o.RunID, row["runId"])
+			assert.NotEmpty(t, row["timestamp"])
+			switch row["fileName"] {
+			case "a.jpg":
+				assert.Equal(t, statusDuplicate, row["status"])
+				assert.NotEmpty(t, row["sha256"])
+			case "empty.jpg":
+				assert.Equal(t, statusSkipped, row["status"])
+				assert.Equal(t, "has size 0", row["reason"])
+			}
+		}
+	}
+
+	summaries, err := readLog(summaryPath(logPath))
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "completed", summaries[1]["status"])
+	assert.Equal(t, "1", summaries[1]["duplicates"])
+	assert.Equal(t, "1", summaries[1]["skipped"])
+}
@@ -0,0 +1,306 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// exifBatchSize is how many files are handed to exiftool in a single request.
+const exifBatchSize = 64
+
+// metadataCache keeps the EXIF tags of files between runs, keyed by path, size and mtime,
+// so files which didn't change since the last run don't go through exiftool again.
+type metadataCache struct {
+	mu      sync.Mutex
+	path    string
+	dirty   bool
+	Tags    []string                     `json:"tags"`    // tags requested when the entries were extracted
+	Entries map[string]map[string]string `json:"entries"` // [path|size|mtime][tag]value
+}
+
+// defaultCachePath returns the cache file inside the user's cache directory, or "" if there is none.
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "organizer", "exif-cache.json")
+}
+
+// loadMetadataCache reads the cache at cachePath. A missing file, or one extracted with fewer tags
+// than tags, starts an empty cache. An empty cachePath gives a cache that's never saved.
+func loadMetadataCache(cachePath string, tags []string) *metadataCache {
+	c := &metadataCache{path: cachePath, Tags: tags, Entries: make(map[string]map[string]string)}
+	if cachePath == "" {
+		return c
+	}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to read EXIF cache, starting an empty one", "path", cachePath, "error", err)
+		}
+		return c
+	}
+
+	var stored metadataCache
+	if err := json.Unmarshal(data, &stored); err != nil {
+		slog.Warn("failed to parse EXIF cache, starting an empty one", "path", cachePath, "error", err)
+		return c
+	}
+	for _, tag := range tags {
+		if !slices.Contains(stored.Tags, tag) {
+			slog.Info("EXIF cache was made for other tags, starting an empty one", "path", cachePath)
+			return c
+		}
+	}
+	if stored.Entries != nil {
+		c.Entries = stored.Entries
+	}
+	return c
+}
+
+// cacheKey identifies the content of fp without reading it.
+func cacheKey(fp string, info os.FileInfo) string {
+	if abs, err := filepath.Abs(fp); err == nil {
+		fp = abs
+	}
+	return fmt.Sprintf("%s|%d|%d", fp, info.Size(), info.ModTime().UnixNano())
+}
+
+func (c *metadataCache) get(key string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fields, exists := c.Entries[key]
+	return fields, exists
+}
+
+// put stores the requested tags out of fields, files without any of them are stored too,
+// so they aren't extracted again either.
+func (c *metadataCache) put(key string, fields map[string]interface{}) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range c.Tags {
+		if value, ok := fields[tag].(string); ok {
+			tags[tag] = value
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[key] = tags
+	c.dirty = true
+	return tags
+}
+
+// prune drops the entries of the files below root which aren't in seen: files which were moved, deleted
+// or changed since they were cached. Entries of files outside root are kept for the runs over them.
+func (c *metadataCache) prune(root string, seen map[string]bool) {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.Entries {
+		if seen[key] {
+			continue
+		}
+		rel, err := filepath.Rel(root, keyPath(key))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		delete(c.Entries, key)
+		c.dirty = true
+	}
+}
+
+// keyPath returns the path of a cacheKey, paths may have '|' in them, size and mtime don't.
+func keyPath(key string) string {
+	for range 2 {
+		if i := strings.LastIndex(key, "|"); i >= 0 {
+			key = key[:i]
+		}
+	}
+	return key
+}
+
+// save writes the cache back into its file, if anything changed.
+func (c *metadataCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	if err := createDirectory(path.Dir(filepath.ToSlash(c.path))); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// exifTags returns the EXIF tags the date sources of the sort rules ask for.
+func (o *Operator) exifTags() []string {
+	tags := make([]string, 0)
+	addTags := func(sources []string) {
+		for _, source := range sources {
+			if tag, isExif := strings.CutPrefix(source, dateSourceExif); isExif && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	for category := range o.Storage.SortMap {
+		if sources, exists := o.Storage.DateSources[category]; exists {
+			addTags(sources)
+		} else {
+			addTags(defaultDateSources)
+		}
+	}
+	if len(o.Storage.SortMap) == 0 {
+		addTags(defaultDateSources)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// needsExif reports whether files of category are dated by an exif date source.
+func (o *Operator) needsExif(category string) bool {
+	if _, exists := o.GetSortSubDirs(category); !exists {
+		return false
+	}
+	sources, exists := o.Storage.DateSources[category]
+	if !exists {
+		sources = defaultDateSources
+	}
+	return slices.ContainsFunc(sources, func(source string) bool {
+		return strings.HasPrefix(source, dateSourceExif)
+	})
+}
+
+// prefetchMetadata walks the source directory before processing it, and extracts the EXIF tags of every file
+// which is going to be dated by exif and isn't cached yet. The native reader goes first, the files it can't read
+// are handed to exiftool in batches of exifBatchSize files per request.
+// go-exiftool still sends one -execute per file, but holds its process for the whole batch.
+// The walk also counts the files and bytes progress reports against,
+// directories it can't read are recorded by failDir and left out.
+// Cache entries of files below --src the walk didn't come across are dropped, unless it was cancelled.
+// Once ctx is cancelled, the files left are skipped.
+func (o *Operator) prefetchMetadata(ctx context.Context) error {
+	o.cache = loadMetadataCache(o.Flags.ExifCache, o.exifTags())
+
+	pending := make([]string, 0)
+	seen := make(map[string]bool) // cache keys of the files dated by exif
+	var walk func(dirpath string)
+	walk = func(dirpath string) {
+		entries, err := os.ReadDir(dirpath)
+		if err != nil {
+			o.failDir(dirpath, err)
+			return
+		}
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			fp := path.Join(dirpath, entry.Name())
+			if entry.IsDir() {
+				walk(fp)
+				continue
+			}
+			o.progress.expect(fileSize(fp))
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+				continue
+			}
+			match := o.classify(o.fileExtension(fp), fp)
+			if !o.needsExif(match.Category) {
+				continue
+			}
+			key := cacheKey(fp, info)
+			seen[key] = true
+			if _, cached := o.cache.get(key); !cached {
+				pending = append(pending, fp)
+			}
+		}
+	}
+	walk(o.Flags.SrcPath)
+	if ctx.Err() == nil {
+		o.cache.prune(o.Flags.SrcPath, seen)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	native := o.nativeReadable()
+	viaExiftool := make([]string, 0)
+	for _, fp := range pending {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if native {
+			info, err := os.Stat(fp)
+			if err != nil {
+				continue
+			}
+			if fields, err := readNativeDates(fp); err == nil {
+				o.cache.put(cacheKey(fp, info), fields)
+				continue
+			}
+		}
+		viaExiftool = append(viaExiftool, fp)
+	}
+	slog.Info("extracting EXIF dates", "files", len(pending), "exiftool", len(viaExiftool))
+	if len(viaExiftool) == 0 {
+		return nil
+	}
+	exif, err := o.exif()
+	if err != nil {
+		// already logged by exif, these files are left to the other date sources.
+		return nil
+	}
+	// every batch waits for a free process of the pool, so they run in parallel.
+	var wg sync.WaitGroup
+	for batch := range slices.Chunk(viaExiftool, exifBatchSize) {
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			for _, fileInfo := range exif.ExtractMetadata(batch...) {
+				if fileInfo.Err != nil {
+					slog.Warn("failed to read EXIF data", "path", fileInfo.File, "error", fileInfo.Err)
+					continue
+				}
+				info, err := os.Stat(fileInfo.File)
+				if err != nil {
+					continue
+				}
+				o.cache.put(cacheKey(fileInfo.File, info), fileInfo.Fields)
+			}
+		}(batch)
+	}
+	wg.Wait()
+	return nil
+}
+
+// saveMetadata writes the EXIF cache, dry-run leaves it alone like everything else.
+func (o *Operator) saveMetadata() {
+	if o.cache == nil || o.Flags.DryRun {
+		return
+	}
+	if err := o.cache.save(); err != nil {
+		slog.Warn("failed to save EXIF cache", "path", o.cache.path, "error", err)
+	}
+}
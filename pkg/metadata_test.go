@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_metadataCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := path.Join(dir, "cache", "exif-cache.json")
+	fp := path.Join(dir, "IMG_1.jpg")
+	require.NoError(t, os.WriteFile(fp, []byte("jpg"), 0o644))
+	info, err := os.Stat(fp)
+	require.NoError(t, err)
+
+	tags := []string{"CreateDate", "DateTimeOriginal"}
+	c := loadMetadataCache(cachePath, tags)
+	stored := c.put(cacheKey(fp, info), map[string]interface{}{"CreateDate": "2022:12:08 19:09:53", "FileSize": "3 bytes"})
+	assert.Equal(t, map[string]string{"CreateDate": "2022:12:08 19:09:53"}, stored)
+	require.NoError(t, c.save())
+
+	c = loadMetadataCache(cachePath, tags[:1])
+	fields, cached := c.get(cacheKey(fp, info))
+	require.True(t, cached)
+	assert.Equal(t, "2022:12:08 19:09:53", fields["CreateDate"])
+
+	// a changed file has another key
+	require.NoError(t, os.Chtimes(fp, time.Now(), time.Now().Add(time.Hour)))
+	info, err = os.Stat(fp)
+	require.NoError(t, err)
+	_, cached = c.get(cacheKey(fp, info))
+	assert.False(t, cached)
+
+	// entries without a newly requested tag can't be trusted
+	c = loadMetadataCache(cachePath, []string{"CreateDate", "MediaCreateDate"})
+	assert.Empty(t, c.Entries)
+}
+
+func Test_metadataCache_prune(t *testing.T) {
+	dir := t.TempDir()
+	src, other := filepath.Join(dir, "src"), filepath.Join(dir, "src2")
+	c := loadMetadataCache("", []string{"CreateDate"})
+	current := filepath.Join(src, "a|b.jpg") + "|3|1"
+	deleted := filepath.Join(src, "sub", "old.jpg") + "|3|1"
+	outside := filepath.Join(other, "b.jpg") + "|3|1"
+	for _, key := range []string{current, deleted, outside} {
+		c.Entries[key] = map[string]string{"CreateDate": "2022:12:08 19:09:53"}
+	}
+
+	c.prune(src, map[string]bool{current: true})
+	assert.Contains(t, c.Entries, current)
+	assert.NotContains(t, c.Entries, deleted, "files below --src the run didn't come across are dropped")
+	assert.Contains(t, c.Entries, outside, "files of other sources are kept, src2 isn't below src")
+	assert.True(t, c.dirty)
+	assert.Equal(t, filepath.Join(src, "a|b.jpg"), keyPath(current))
+}
@@ -0,0 +1,554 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrorUnsupportedFormat = errors.New("file format isn't supported by the native EXIF reader")
+
+// nativeDateTags are the tags readNativeDates finds, named like exiftool names them.
+// Date sources asking for any other tag go through exiftool.
+var nativeDateTags = []string{"DateTimeOriginal", "CreateDate", "ModifyDate", "MediaCreateDate", "CreationTime"}
+
+const (
+	maxTextChunk = 1 << 20 // PNG text chunks and JPEG segments bigger than this are skipped
+	maxDateValue = 64
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	// xmpDate finds dates written either as attributes or as elements, e.g. xmp:CreateDate="2022-12-08T19:09:53".
+	xmpDate = regexp.MustCompile(`\w+:(DateTimeOriginal|CreateDate|ModifyDate)(?:\s*=\s*"([^"]*)"|>([^<]*)<)`)
+	// quickTimeEpoch is where mvhd and mdhd count their seconds from.
+	quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// readNativeDates reads the date tags of fp without exiftool, out of JPEG and TIFF EXIF, PNG eXIf and text chunks,
+// XMP packets, HEIC Exif items and the mvhd and mdhd boxes of MP4 and QuickTime files.
+// Values are formatted like exiftool's, e.g. "2022:12:08 19:09:53". Other formats return ErrorUnsupportedFormat.
+func readNativeDates(fp string) (map[string]interface{}, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+
+	fields := make(map[string]interface{})
+	switch {
+	case bytes.HasPrefix(header, []byte{0xff, 0xd8}):
+		err = readJPEG(f, fields)
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		err = readTIFF(f, fields)
+	case bytes.HasPrefix(header, pngSignature):
+		err = readPNG(f, info.Size(), fields)
+	case len(header) == 12 && string(header[4:8]) == "ftyp":
+		err = readISOBMFF(f, info.Size(), fields)
+	default:
+		return nil, ErrorUnsupportedFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", fp, err)
+	}
+	return fields, nil
+}
+
+// setDate stores value under tag if it's a date and the tag isn't set yet, the first value found wins.
+func setDate(fields map[string]interface{}, tag, value string) {
+	if _, exists := fields[tag]; exists {
+		return
+	}
+	if date := normalizeDate(value); date != "" {
+		fields[tag] = date
+	}
+}
+
+// normalizeDate turns EXIF, ISO 8601 and RFC 1123 dates into exif's "2006:01:02 15:04:05", or "" if value isn't a date.
+// Time zones are dropped, like parseExifDate drops them.
+func normalizeDate(value string) string {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	layouts := []string{exifDateLayout, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+	for _, layout := range layouts {
+		if len(value) < len(layout) {
+			continue
+		}
+		if t, err := time.Parse(layout, value[:len(layout)]); err == nil && !t.IsZero() {
+			return t.Format(exifDateLayout)
+		}
+	}
+	for _, layout := range []string{time.RFC1123, time.RFC1123Z, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil && !t.IsZero() {
+			return t.Format(exifDateLayout)
+		}
+	}
+	return ""
+}
+
+// readJPEG walks the segments before the image data, looking for EXIF and XMP in APP1 segments.
+func readJPEG(r io.ReadSeeker, fields map[string]interface{}) error {
+	if _, err := r.Seek(2, io.SeekStart); err != nil {
+		return err
+	}
+	marker := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, marker[:2]); err != nil {
+			return err
+		}
+		if marker[0] != 0xff {
+			return errors.New("invalid JPEG marker")
+		}
+		switch {
+		case marker[1] == 0xff: // fill byte
+			if _, err := r.Seek(-1, io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		case marker[1] == 0xda || marker[1] == 0xd9: // start of scan or end of image, no metadata after that
+			return nil
+		case marker[1] == 0x01 || (marker[1] >= 0xd0 && marker[1] <= 0xd7): // markers without a length
+			continue
+		}
+
+		if _, err := io.ReadFull(r, marker[2:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return errors.New("invalid JPEG segment length")
+		}
+		if marker[1] != 0xe1 || length > maxTextChunk {
+			if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(segment, exifHeader):
+			if err := readTIFF(bytes.NewReader(segment[len(exifHeader):]), fields); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(segment, xmpHeader):
+			readXMP(segment[len(xmpHeader):], fields)
+		}
+	}
+}
+
+// readTIFF reads ModifyDate out of IFD0, and DateTimeOriginal and CreateDate out of the Exif IFD.
+// r starts at the TIFF header.
+func readTIFF(r io.ReaderAt, fields map[string]interface{}) error {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return errors.New("invalid TIFF header")
+	}
+
+	exifIFD, err := readIFD(r, order, int64(order.Uint32(header[4:])), map[uint16]string{0x0132: "ModifyDate"}, fields)
+	if err != nil || exifIFD == 0 {
+		return err
+	}
+	_, err = readIFD(r, order, exifIFD, map[uint16]string{0x9003: "DateTimeOriginal", 0x9004: "CreateDate"}, fields)
+	return err
+}
+
+// readIFD stores the ASCII tags of names found in the IFD at offset, and returns the offset of the Exif IFD, 0 if there is none.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset int64, names map[uint16]string, fields map[string]interface{}) (int64, error) {
+	count := make([]byte, 2)
+	if _, err := r.ReadAt(count, offset); err != nil {
+		return 0, err
+	}
+	entries := make([]byte, 12*int(order.Uint16(count)))
+	if _, err := r.ReadAt(entries, offset+2); err != nil {
+		return 0, err
+	}
+
+	var exifIFD int64
+	for entry := range slices.Chunk(entries, 12) {
+		tag, kind, n := order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:])
+		if tag == 0x8769 {
+			exifIFD = int64(order.Uint32(entry[8:]))
+			continue
+		}
+		name, wanted := names[tag]
+		if !wanted || kind != 2 || n > maxDateValue { // 2 is ASCII
+			continue
+		}
+		value := entry[8 : 8+min(n, 4)]
+		if n > 4 {
+			value = make([]byte, n)
+			if _, err := r.ReadAt(value, int64(order.Uint32(entry[8:]))); err != nil {
+				return 0, err
+			}
+		}
+		setDate(fields, name, string(value))
+	}
+	return exifIFD, nil
+}
+
+// readXMP picks the dates out of an XMP packet, without a full RDF parser.
+func readXMP(packet []byte, fields map[string]interface{}) {
+	for _, m := range xmpDate.FindAllSubmatch(packet, -1) {
+		value := m[2]
+		if len(value) == 0 {
+			value = m[3]
+		}
+		setDate(fields, string(m[1]), string(value))
+	}
+}
+
+// readPNG goes through the chunks: eXIf holds EXIF, tEXt, zTXt and iTXt hold XMP or dates keyed by
+// "create-date", "modify-date" and "Creation Time".
+func readPNG(r io.ReaderAt, size int64, fields map[string]interface{}) error {
+	header := make([]byte, 8)
+	for offset := int64(len(pngSignature)); offset+8 <= size; {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return err
+		}
+		length, kind := int64(binary.BigEndian.Uint32(header)), string(header[4:])
+		data := io.NewSectionReader(r, offset+8, length)
+		offset += 12 + length // length, type, data and crc
+
+		switch kind {
+		case "IEND":
+			return nil
+		case "eXIf":
+			if err := readTIFF(data, fields); err != nil {
+				return err
+			}
+		case "tEXt", "zTXt", "iTXt":
+			if length > maxTextChunk {
+				continue
+			}
+			chunk := make([]byte, length)
+			if _, err := data.ReadAt(chunk, 0); err != nil {
+				return err
+			}
+			keyword, text, err := pngText(kind, chunk)
+			if err != nil {
+				return err
+			}
+			switch keyword {
+			case "XML:com.adobe.xmp":
+				readXMP(text, fields)
+			case "create-date":
+				setDate(fields, "CreateDate", string(text))
+			case "modify-date":
+				setDate(fields, "ModifyDate", string(text))
+			case "Creation Time":
+				setDate(fields, "CreationTime", string(text))
+			}
+		}
+	}
+	return nil
+}
+
+// pngText splits a text chunk into its keyword and its text, inflating compressed text.
+func pngText(kind string, chunk []byte) (string, []byte, error) {
+	keyword, text, found := bytes.Cut(chunk, []byte{0})
+	if !found {
+		return "", nil, nil
+	}
+	compressed := false
+	switch kind {
+	case "zTXt": // compression method, text
+		compressed, text = true, text[min(1, len(text)):]
+	case "iTXt": // compression flag, compression method, language\0, translated keyword\0, text
+		if len(text) < 2 {
+			return "", nil, nil
+		}
+		compressed = text[0] == 1
+		parts := bytes.SplitN(text[2:], []byte{0}, 3)
+		if len(parts) < 3 {
+			return "", nil, nil
+		}
+		text = parts[2]
+	}
+	if !compressed {
+		return string(keyword), text, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return "", nil, err
+	}
+	text, err = io.ReadAll(io.LimitReader(zr, maxTextChunk))
+	return string(keyword), text, err
+}
+
+// isoBox is a box of an ISO base media file, offset and size are those of its payload.
+type isoBox struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+// readBoxes calls fn for every box between start and end, without reading their payloads.
+func readBoxes(r io.ReaderAt, start, end int64, fn func(b isoBox) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0: // up to the end
+			size = end - offset
+		case 1: // 64 bit size after the type
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || offset+size > end {
+			return fmt.Errorf("invalid size of box %q", header[4:8])
+		}
+		if err := fn(isoBox{kind: string(header[4:8]), offset: offset + headerSize, size: size - headerSize}); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+// readISOBMFF reads MP4 and QuickTime dates out of moov, and HEIC EXIF out of meta.
+func readISOBMFF(r io.ReaderAt, size int64, fields map[string]interface{}) error {
+	return readBoxes(r, 0, size, func(b isoBox) error {
+		switch b.kind {
+		case "moov":
+			return readMovie(r, b, fields)
+		case "meta":
+			return readHEIFExif(r, b, fields)
+		}
+		return nil
+	})
+}
+
+// readMovie reads CreateDate out of mvhd and MediaCreateDate out of the mdhd of the first track.
+func readMovie(r io.ReaderAt, moov isoBox, fields map[string]interface{}) error {
+	var walk func(parent isoBox) error
+	walk = func(parent isoBox) error {
+		return readBoxes(r, parent.offset, parent.offset+parent.size, func(b isoBox) error {
+			switch b.kind {
+			case "trak", "mdia":
+				return walk(b)
+			case "mvhd":
+				return readMovieDate(r, b, "CreateDate", fields)
+			case "mdhd":
+				return readMovieDate(r, b, "MediaCreateDate", fields)
+			}
+			return nil
+		})
+	}
+	return walk(moov)
+}
+
+// readMovieDate reads the creation time of a mvhd or mdhd box, in seconds since 1904 UTC. 0 means it isn't set.
+func readMovieDate(r io.ReaderAt, b isoBox, tag string, fields map[string]interface{}) error {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, b.offset); err != nil {
+		return err
+	}
+	seconds := uint64(binary.BigEndian.Uint32(header[4:]))
+	if header[0] == 1 { // version 1 has 64 bit times
+		seconds = binary.BigEndian.Uint64(header[4:])
+	}
+	if seconds == 0 {
+		return nil
+	}
+	setDate(fields, tag, quickTimeEpoch.Add(time.Duration(seconds)*time.Second).Format(exifDateLayout))
+	return nil
+}
+
+// readHEIFExif finds the Exif item of a HEIC meta box through iinf, locates it with iloc and reads its TIFF data.
+func readHEIFExif(r io.ReaderAt, meta isoBox, fields map[string]interface{}) error {
+	var exifID uint32
+	var iloc isoBox
+	// meta is a full box, its children start after version and flags.
+	err := readBoxes(r, meta.offset+4, meta.offset+meta.size, func(b isoBox) error {
+		switch b.kind {
+		case "iinf":
+			id, err := exifItemID(r, b)
+			exifID = id
+			return err
+		case "iloc":
+			iloc = b
+		}
+		return nil
+	})
+	if err != nil || exifID == 0 || iloc.kind == "" {
+		return err
+	}
+
+	offset, length, err := itemExtent(r, iloc, exifID)
+	if err != nil || length < 4 {
+		return err
+	}
+	// the item starts with the offset of the TIFF header, after "Exif\0\0".
+	skip := make([]byte, 4)
+	if _, err := r.ReadAt(skip, offset); err != nil {
+		return err
+	}
+	start := 4 + int64(binary.BigEndian.Uint32(skip))
+	if start >= length {
+		return errors.New("invalid HEIC Exif item")
+	}
+	return readTIFF(io.NewSectionReader(r, offset+start, length-start), fields)
+}
+
+// exifItemID returns the id of the item of type Exif listed in iinf, 0 if there is none.
+func exifItemID(r io.ReaderAt, iinf isoBox) (uint32, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, iinf.offset); err != nil {
+		return 0, err
+	}
+	start := iinf.offset + 6 // version 0 has a 16 bit entry count
+	if header[0] != 0 {
+		start = iinf.offset + 8
+	}
+
+	var id uint32
+	err := readBoxes(r, start, iinf.offset+iinf.size, func(b isoBox) error {
+		if b.kind != "infe" || id != 0 {
+			return nil
+		}
+		entry := make([]byte, 16)
+		if _, err := r.ReadAt(entry[:min(b.size, 16)], b.offset); err != nil {
+			return err
+		}
+		switch entry[0] { // version 2 has a 16 bit item id, version 3 a 32 bit one, older ones have no type
+		case 2:
+			if string(entry[8:12]) == "Exif" {
+				id = uint32(binary.BigEndian.Uint16(entry[4:]))
+			}
+		case 3:
+			if string(entry[10:14]) == "Exif" {
+				id = binary.BigEndian.Uint32(entry[4:])
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+// itemExtent returns the file offset and the length of the first extent of item id in iloc.
+func itemExtent(r io.ReaderAt, iloc isoBox, id uint32) (int64, int64, error) {
+	if iloc.size > maxTextChunk {
+		return 0, 0, errors.New("iloc box is too big")
+	}
+	data := make([]byte, iloc.size)
+	if _, err := r.ReadAt(data, iloc.offset); err != nil {
+		return 0, 0, err
+	}
+
+	pos := 0
+	read := func(size int) (uint64, error) {
+		if pos+size > len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		var v uint64
+		for _, b := range data[pos : pos+size] {
+			v = v<<8 | uint64(b)
+		}
+		pos += size
+		return v, nil
+	}
+
+	version, _ := read(1)
+	pos += 3 // flags
+	sizes, _ := read(1)
+	moreSizes, _ := read(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	baseOffsetSize, indexSize := int(moreSizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(moreSizes & 0xf)
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+
+	itemCount, err := read(idSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	for range itemCount {
+		itemID, err := read(idSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			if constructionMethod, err = read(2); err != nil {
+				return 0, 0, err
+			}
+			constructionMethod &= 0xf
+		}
+		pos += 2 // data reference index
+		baseOffset, err := read(baseOffsetSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		extentCount, err := read(2)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var offset, length uint64
+		for extent := range extentCount {
+			pos += indexSize
+			extentOffset, err := read(offsetSize)
+			if err != nil {
+				return 0, 0, err
+			}
+			extentLength, err := read(lengthSize)
+			if err != nil {
+				return 0, 0, err
+			}
+			if extent == 0 {
+				offset, length = baseOffset+extentOffset, extentLength
+			}
+		}
+		if uint32(itemID) != id {
+			continue
+		}
+		if constructionMethod != 0 { // 0 is a plain file offset, items inside idat aren't handled
+			return 0, 0, errors.New("unsupported iloc construction method")
+		}
+		return int64(offset), int64(length), nil
+	}
+	return 0, 0, nil
+}
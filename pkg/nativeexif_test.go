@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// testTIFF builds little endian TIFF data with ModifyDate in IFD0 and DateTimeOriginal in the Exif IFD.
+func testTIFF() []byte {
+	le := binary.LittleEndian
+	b := new(bytes.Buffer)
+	b.WriteString("II*\x00")
+	_ = binary.Write(b, le, uint32(8))
+	// IFD0 at 8: ModifyDate and the Exif IFD pointer
+	_ = binary.Write(b, le, uint16(2))
+	_ = binary.Write(b, le, []uint16{0x0132, 2})
+	_ = binary.Write(b, le, []uint32{20, 56})
+	_ = binary.Write(b, le, []uint16{0x8769, 4})
+	_ = binary.Write(b, le, []uint32{1, 38})
+	_ = binary.Write(b, le, uint32(0))
+	// Exif IFD at 38: DateTimeOriginal
+	_ = binary.Write(b, le, uint16(1))
+	_ = binary.Write(b, le, []uint16{0x9003, 2})
+	_ = binary.Write(b, le, []uint32{20, 76})
+	_ = binary.Write(b, le, uint32(0))
+	b.WriteString("2023:01:02 03:04:05\x00")
+	b.WriteString("2021:05:06 07:08:09\x00")
+	return b.Bytes()
+}
+
+func box(kind string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(data))), append([]byte(kind), data...)...)
+}
+
+func Test_readNativeDates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		fp := path.Join(dir, name)
+		require.NoError(t, os.WriteFile(fp, data, 0o644))
+		return fp
+	}
+	tiff := testTIFF()
+	want := map[string]interface{}{"ModifyDate": "2023:01:02 03:04:05", "DateTimeOriginal": "2021:05:06 07:08:09"}
+
+	t.Run("png text chunk", func(t *testing.T) {
+		fields, err := readNativeDates("./evil_gopher.png")
+		require.NoError(t, err)
+		assert.Equal(t, "2022:12:08 19:09:53", fields["CreateDate"])
+	})
+
+	t.Run("jpeg", func(t *testing.T) {
+		app1 := append([]byte("Exif\x00\x00"), tiff...)
+		jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 0, 0, 0xff, 0xe1}
+		jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(app1)+2))
+		jpeg = append(append(jpeg, app1...), 0xff, 0xda, 0, 2, 0xff, 0xd9)
+		fields, err := readNativeDates(write("a.jpg", jpeg))
+		require.NoError(t, err)
+		assert.Equal(t, want, fields)
+	})
+
+	t.Run("tiff", func(t *testing.T) {
+		fields, err := readNativeDates(write("a.tif", tiff))
+		require.NoError(t, err)
+		assert.Equal(t, want, fields)
+	})
+
+	t.Run("mp4", func(t *testing.T) {
+		created := uint32(time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC).Sub(quickTimeEpoch).Seconds())
+		mvhd := binary.BigEndian.AppendUint32(make([]byte, 4), created)
+		mdhd := binary.BigEndian.AppendUint64([]byte{1, 0, 0, 0}, uint64(created)+60)
+		mp4 := append(box("ftyp", []byte("isom\x00\x00\x02\x00")),
+			box("moov", box("mvhd", mvhd, make([]byte, 88)), box("trak", box("mdia", box("mdhd", mdhd, make([]byte, 20)))))...)
+		fields, err := readNativeDates(write("a.mp4", mp4))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"CreateDate": "2020:02:03 04:05:06", "MediaCreateDate": "2020:02:03 04:06:06"}, fields)
+	})
+
+	t.Run("heic", func(t *testing.T) {
+		infe := box("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif"))
+		iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+		item := append(binary.BigEndian.AppendUint32(nil, 6), append([]byte("Exif\x00\x00"), tiff...)...)
+		ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00"))
+		// iloc version 0, 4 byte offsets and lengths, no base offset: a single item with a single extent
+		ilocSize := 8 + 4 + 2 + 2 + 2 + 2 + 2 + 8
+		metaSize := 8 + 4 + len(iinf) + ilocSize
+		offset := len(ftyp) + metaSize + 8
+		ilocPayload := []byte{0, 0, 0, 0, 0x44, 0, 0, 1, 0, 1, 0, 0, 0, 1}
+		ilocPayload = binary.BigEndian.AppendUint32(ilocPayload, uint32(offset))
+		ilocPayload = binary.BigEndian.AppendUint32(ilocPayload, uint32(len(item)))
+		meta := box("meta", make([]byte, 4), iinf, box("iloc", ilocPayload))
+		require.Len(t, meta, metaSize)
+		heic := bytes.Join([][]byte{ftyp, meta, box("mdat", item)}, nil)
+
+		fields, err := readNativeDates(write("a.heic", heic))
+		require.NoError(t, err)
+		assert.Equal(t, want, fields)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := readNativeDates(write("a.txt", []byte("plain text")))
+		require.ErrorIs(t, err, ErrorUnsupportedFormat)
+	})
+}
+
+func Test_normalizeDate(t *testing.T) {
+	assert.Equal(t, "2022:12:08 19:09:53", normalizeDate("2022:12:08 19:09:53.12+01:00"))
+	assert.Equal(t, "2022:12:08 19:09:53", normalizeDate("2022-12-08T19:09:53+01:00"))
+	assert.Equal(t, "2022:12:08 19:09:53", normalizeDate("Thu, 08 Dec 2022 19:09:53 GMT"))
+	assert.Equal(t, "", normalizeDate("0000:00:00 00:00:00"))
+	assert.Equal(t, "", normalizeDate("yesterday"))
+}
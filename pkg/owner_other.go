@@ -0,0 +1,10 @@
+//go:build !unix
+
+package pkg
+
+import "os"
+
+// fileOwner isn't supported outside of unix.
+func fileOwner(_ os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package pkg
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of info.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
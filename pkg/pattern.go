@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorNoPatternDate = errors.New("file name doesn't match the date pattern")
+
+// patternTokens are the placeholders of --pattern and the regexp each one matches.
+// "ext" stands for any file extension.
+var patternTokens = []struct {
+	token string
+	expr  string
+}{
+	{"YEAR", `(?P<YEAR>\d{4})`},
+	{"MONTH", `(?P<MONTH>\d{2})`},
+	{"DAY", `(?P<DAY>\d{2})`},
+	{"HOUR", `(?P<HOUR>\d{2})`},
+	{"MINUTE", `(?P<MINUTE>\d{2})`},
+	{"SECOND", `(?P<SECOND>\d{2})`},
+	{"ext", `[^.]+`},
+	{"*", `.*?`},
+}
+
+// DatePattern reads dates out of file names, e.g. IMG_YEARMONTHDAY_HOURMINUTESECOND.ext
+// matches IMG_20220830_195427.jpg. Everything that isn't a token has to match literally.
+type DatePattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// CompilePattern compiles the --pattern flag, an empty pattern returns nil.
+func CompilePattern(pattern string) (*DatePattern, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	seen := make(map[string]bool)
+	for rest := pattern; rest != ""; {
+		matched := false
+		for _, t := range patternTokens {
+			if strings.HasPrefix(rest, t.token) {
+				if seen[t.token] && t.token != "*" && t.token != "ext" {
+					return nil, fmt.Errorf("pattern %q has %s more than once", pattern, t.token)
+				}
+				seen[t.token] = true
+				expr.WriteString(t.expr)
+				rest = rest[len(t.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			expr.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	expr.WriteString("$")
+
+	if !seen["YEAR"] {
+		return nil, fmt.Errorf("pattern %q needs at least the YEAR token", pattern)
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &DatePattern{source: pattern, re: re}, nil
+}
+
+// Date returns the date inside fileName, tokens missing from the pattern default to their lowest value.
+func (p *DatePattern) Date(fileName string) (time.Time, error) {
+	if p == nil {
+		return time.Time{}, ErrorNoPatternDate
+	}
+	match := p.re.FindStringSubmatch(fileName)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("%w: %s doesn't match %s", ErrorNoPatternDate, fileName, p.source)
+	}
+
+	values := map[string]int{"MONTH": 1, "DAY": 1}
+	for i, name := range p.re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		v, err := strconv.Atoi(match[i])
+		if err != nil {
+			return time.Time{}, err
+		}
+		values[name] = v
+	}
+
+	t := time.Date(values["YEAR"], time.Month(values["MONTH"]), values["DAY"],
+		values["HOUR"], values["MINUTE"], values["SECOND"], 0, time.Local)
+	// time.Date normalizes overflows like month 13, those aren't dates.
+	if t.Month() != time.Month(values["MONTH"]) || t.Day() != values["DAY"] || t.Hour() != values["HOUR"] ||
+		t.Minute() != values["MINUTE"] || t.Second() != values["SECOND"] {
+		return time.Time{}, fmt.Errorf("%w: %s has an invalid date for %s", ErrorNoPatternDate, fileName, p.source)
+	}
+	return t, nil
+}
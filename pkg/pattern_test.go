@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_DatePattern(t *testing.T) {
+	p, err := CompilePattern("IMG_YEARMONTHDAY_HOURMINUTESECOND.ext")
+	require.NoError(t, err)
+
+	{
+		date, err := p.Date("IMG_20220830_195427.jpg")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2022, 8, 30, 19, 54, 27, 0, time.Local), date)
+	}
+	{
+		_, err := p.Date("IMG_20221330_195427.jpg")
+		require.ErrorIs(t, err, ErrorNoPatternDate)
+	}
+	{
+		_, err := p.Date("VID_20220830_195427.mp4")
+		require.ErrorIs(t, err, ErrorNoPatternDate)
+	}
+
+	p, err = CompilePattern("*YEAR-MONTH*")
+	require.NoError(t, err)
+	date, err := p.Date("Screenshot from 2021-03 (2).png")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local), date)
+
+	_, err = CompilePattern("IMG_MONTHDAY")
+	require.Error(t, err)
+	p, err = CompilePattern("")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
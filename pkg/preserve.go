@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+const (
+	PreserveTimes = "times"
+	PreserveMode  = "mode"
+	PreserveOwner = "owner"
+)
+
+var preserveAttributes = []string{PreserveTimes, PreserveMode, PreserveOwner}
+
+// preserveAttrs copies the attributes listed in preserve from srcInfo to dst.
+// Ownership can only be given away by root, for everyone else it's silently left out.
+func preserveAttrs(dst string, srcInfo os.FileInfo, preserve []string) error {
+	// the owner goes first, chown clears the setuid and setgid bits of the mode.
+	if slices.Contains(preserve, PreserveOwner) && os.Geteuid() == 0 {
+		if uid, gid, ok := fileOwner(srcInfo); ok {
+			if err := os.Lchown(dst, uid, gid); err != nil {
+				return fmt.Errorf("failed to preserve owner of %s: %w", dst, err)
+			}
+		}
+	}
+	if slices.Contains(preserve, PreserveMode) {
+		mode := srcInfo.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := os.Chmod(dst, mode); err != nil {
+			return fmt.Errorf("failed to preserve mode of %s: %w", dst, err)
+		}
+	}
+	// times go last, so nothing above can touch them again.
+	if slices.Contains(preserve, PreserveTimes) {
+		if err := os.Chtimes(dst, fileAtime(srcInfo), srcInfo.ModTime()); err != nil {
+			return fmt.Errorf("failed to preserve times of %s: %w", dst, err)
+		}
+	}
+	return nil
+}
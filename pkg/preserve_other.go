@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pkg
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime returns the access time of info, or its mtime if the platform doesn't tell.
+func fileAtime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_preserveAttrs(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "tool"), path.Join(dir, "copy")
+	require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh"), 0o644))
+	require.NoError(t, os.WriteFile(dst, []byte("#!/bin/sh"), 0o644))
+	mode := os.FileMode(0o750) | os.ModeSetuid
+	if runtime.GOOS == "windows" {
+		mode = 0o444 // only the read-only bit exists there
+	}
+	require.NoError(t, os.Chmod(src, mode))
+	mtime := time.Date(2020, 5, 17, 8, 30, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+	srcInfo, err := os.Stat(src)
+	require.NoError(t, err)
+
+	require.NoError(t, preserveAttrs(dst, srcInfo, preserveAttributes))
+
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()), "mtime %s", info.ModTime())
+	assert.Equal(t, srcInfo.Mode(), info.Mode(), "the owner is set before the mode, chown would clear setuid")
+
+	require.NoError(t, os.Chmod(dst, 0o644))
+	require.NoError(t, os.Chtimes(dst, time.Now(), time.Now()))
+	require.NoError(t, preserveAttrs(dst, srcInfo, []string{PreserveTimes}))
+	info, err = os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm(), "only the listed attributes are preserved")
+}
@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	barRefresh          = 200 * time.Millisecond // how often the terminal bar is redrawn
+	progressLogInterval = 10 * time.Second       // how often progress is logged when stderr isn't a terminal
+	barWidth            = 30
+)
+
+// progress tracks the files and bytes of the whole run against the totals counted by the pre-scan
+// in prefetchMetadata, for the sync and async traversal alike.
+// On a terminal it draws a live bar on stderr, otherwise it logs a progress line every progressLogInterval.
+type progress struct {
+	totalFiles int64
+	totalBytes int64
+	files      atomic.Int64
+	bytes      atomic.Int64
+	failed     atomic.Int64 // files which failed or were interrupted, they aren't counted as done
+	lostBytes  atomic.Int64 // bytes of the failed files, the ETA doesn't wait for them
+	started    time.Time
+	tty        bool
+	out        io.Writer // stderr, the bar is drawn on
+	stdout     io.Writer // where println prints, e.g. the --dry-run plan
+	mu         sync.Mutex
+	drawn      bool // the bar is on the last line of out
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+func newProgress() *progress {
+	return &progress{out: os.Stderr, stdout: os.Stdout}
+}
+
+// expect adds a file of size bytes to the totals, it's called by the pre-scan.
+func (p *progress) expect(size int64) {
+	p.totalFiles++
+	p.totalBytes += size
+}
+
+// count counts the file of a row of the log: copied, planned and skipped files are done,
+// failed and interrupted ones are counted apart. Rows of directories, which have no file name, aren't counted.
+func (p *progress) count(e LogEntry) {
+	if e.FileName == "" {
+		return
+	}
+	switch e.Status {
+	case statusFailed, statusInterrupted:
+		p.failed.Add(1)
+		p.lostBytes.Add(e.Size)
+	default:
+		p.files.Add(1)
+		p.bytes.Add(e.Size)
+	}
+}
+
+// fileSize is the size progress counts for the file fp, 0 if it can't be read.
+// Symlinks are followed, as their target is what gets copied.
+func fileSize(fp string) int64 {
+	info, err := os.Stat(fp)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// isTerminal reports whether f is a terminal, e.g. not redirected into a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// start reports progress until finish is called. On a terminal, log lines are routed through the bar,
+// so they're printed above it instead of through it.
+func (p *progress) start() {
+	p.started = time.Now()
+	p.tty = isTerminal(os.Stderr)
+	p.stop, p.stopped = make(chan struct{}), make(chan struct{})
+	interval := progressLogInterval
+	if p.tty {
+		interval = barRefresh
+		log.SetOutput(barWriter{p})
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// finish stops reporting, the last state is drawn once more and log lines go straight to stderr again.
+func (p *progress) finish() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	p.stop = nil
+	if !p.tty {
+		p.report()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(p.out)
+	p.drawn = false
+	log.SetOutput(os.Stderr)
+}
+
+// report draws the bar on a terminal and logs the progress otherwise.
+func (p *progress) report() {
+	if p.tty {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.draw()
+		return
+	}
+	files, bytes := p.files.Load(), p.bytes.Load()
+	rate := p.rate(bytes)
+	slog.Info("progress",
+		"completed", fmt.Sprintf("%.1f%%", p.percent(files, bytes)),
+		"files", fmt.Sprintf("%d/%d", files, p.totalFiles),
+		"failed", p.failed.Load(),
+		"bytes", fmt.Sprintf("%s/%s", formatBytes(bytes), formatBytes(p.totalBytes)),
+		"throughput", formatBytes(int64(rate))+"/s",
+		"eta", p.eta(bytes, rate))
+}
+
+// draw redraws the bar over the last line, p.mu must be held by the caller.
+func (p *progress) draw() {
+	files, bytes := p.files.Load(), p.bytes.Load()
+	fmt.Fprintf(p.out, "\r\x1b[K%s", p.line(files, bytes, p.rate(bytes)))
+	p.drawn = true
+}
+
+// line renders the bar with the counts, throughput and ETA, and the failed files if there are any.
+func (p *progress) line(files, bytes int64, rate float64) string {
+	percent := p.percent(files, bytes)
+	filled := min(barWidth, int(percent/100*barWidth))
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	failed := ""
+	if n := p.failed.Load(); n > 0 {
+		failed = fmt.Sprintf(" (%d failed)", n)
+	}
+	return fmt.Sprintf("[%s] %5.1f%% %d/%d files%s %s/%s %s/s ETA %s", bar, percent, files, p.totalFiles, failed,
+		formatBytes(bytes), formatBytes(p.totalBytes), formatBytes(int64(rate)), p.eta(bytes, rate))
+}
+
+// percent is the share of bytes done, or of files if the files are all empty.
+func (p *progress) percent(files, bytes int64) float64 {
+	switch {
+	case p.totalBytes > 0:
+		return min(100, float64(bytes)/float64(p.totalBytes)*100)
+	case p.totalFiles > 0:
+		return min(100, float64(files)/float64(p.totalFiles)*100)
+	}
+	return 100
+}
+
+// rate is the throughput since start in bytes per second.
+func (p *progress) rate(bytes int64) float64 {
+	elapsed := time.Since(p.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / elapsed
+}
+
+// eta estimates the time left from the throughput so far, "-" until there is one.
+// The bytes of failed files are left to no one, they don't count as time left.
+func (p *progress) eta(bytes int64, rate float64) string {
+	left := p.totalBytes - bytes - p.lostBytes.Load()
+	if left <= 0 {
+		return "0s"
+	}
+	if rate <= 0 {
+		return "-"
+	}
+	return (time.Duration(float64(left) / rate * float64(time.Second))).Round(time.Second).String()
+}
+
+// barWriter is the output of the log package while the bar is drawn: the bar is cleared,
+// the log line printed and the bar drawn again below it.
+type barWriter struct {
+	p *progress
+}
+
+func (w barWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+	if w.p.drawn {
+		fmt.Fprint(w.p.out, "\r\x1b[K")
+	}
+	n, err := w.p.out.Write(b)
+	w.p.draw()
+	return n, err
+}
+
+// println prints line on stdout. A drawn bar is cleared first and drawn again below it, as for log lines,
+// so lines printed while the bar is shown don't end up behind it on the same terminal.
+func (p *progress) println(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.drawn {
+		fmt.Fprintln(p.stdout, line)
+		return
+	}
+	fmt.Fprint(p.out, "\r\x1b[K")
+	fmt.Fprintln(p.stdout, line)
+	p.draw()
+}
+
+// formatBytes formats n with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_progress(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(src, "sub"), 0o755))
+	for name, content := range map[string]string{"a.jpg": "jpg", "b.txt": "", "sub/c.pdf": "pdf content"} {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte(content), 0o644))
+	}
+
+	for _, async := range []bool{false, true} {
+		o, err := GetNewOperator()
+		require.NoError(t, err)
+		o.Flags = Flags{SrcPath: src, DstPath: t.TempDir(), Async: async, Workers: 2}
+		o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+		require.NoError(t, o.CreateSubdirs(o.Flags.DstPath, []Rule{{Category: "images"}}))
+		_, err = o.Operate(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(3), o.progress.totalFiles, "the pre-scan counts files of subdirectories too")
+		assert.Equal(t, int64(14), o.progress.totalBytes)
+		// c.pdf fails, its category directory was never created.
+		assert.Equal(t, int64(2), o.progress.files.Load(), "skipped files count as done")
+		assert.Equal(t, int64(3), o.progress.bytes.Load(), "failed files don't count as done")
+		assert.Equal(t, int64(1), o.progress.failed.Load())
+		assert.Equal(t, int64(11), o.progress.lostBytes.Load())
+	}
+}
+
+func Test_progress_line(t *testing.T) {
+	p := &progress{totalFiles: 4, totalBytes: 4 << 20, started: time.Now().Add(-10 * time.Second)}
+	assert.Equal(t, "[===============               ]  50.0% 2/4 files 2.0 MiB/4.0 MiB 204.8 KiB/s ETA 10s",
+		p.line(2, 2<<20, 2<<20/10))
+	assert.Equal(t, "-", p.eta(0, 0))
+	assert.Equal(t, "0s", p.eta(4<<20, 0))
+
+	p.failed.Add(1)
+	p.lostBytes.Add(1 << 20)
+	assert.Equal(t, "[===============               ]  50.0% 2/4 files (1 failed) 2.0 MiB/4.0 MiB 204.8 KiB/s ETA 5s",
+		p.line(2, 2<<20, 2<<20/10), "the bytes of failed files aren't waited for")
+	assert.Equal(t, "0s", p.eta(3<<20, 0))
+}
+
+func Test_progress_interrupted(t *testing.T) {
+	p := &progress{}
+	p.count(LogEntry{Status: statusSuccess, FileName: "a.jpg", Size: 10})
+	p.count(LogEntry{Status: statusInterrupted, FileName: "b.jpg", Size: 400})
+	p.count(LogEntry{Status: statusFailed, Source: "/src/unreadable"})
+	assert.Equal(t, int64(1), p.files.Load())
+	assert.Equal(t, int64(10), p.bytes.Load(), "an interrupted copy isn't done")
+	assert.Equal(t, int64(1), p.failed.Load(), "rows of directories aren't files")
+	assert.Equal(t, int64(400), p.lostBytes.Load())
+}
+
+func Test_progress_println(t *testing.T) {
+	var out bytes.Buffer
+	p := &progress{totalFiles: 1, out: &out, stdout: &out, started: time.Now()}
+	p.println("a -> b")
+	assert.Equal(t, "a -> b\n", out.String(), "without a bar lines are printed as they are")
+
+	out.Reset()
+	p.drawn = true
+	p.println("c -> d")
+	assert.True(t, strings.HasPrefix(out.String(), "\r\x1b[Kc -> d\n\r\x1b[K[ "), "the bar is cleared and drawn below the line: %q", out.String())
+}
+
+func Test_formatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "3.0 GiB", formatBytes(3<<30))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pkg
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink is only implemented on linux.
+func reflink(_, _ *os.File) error {
+	return errors.ErrUnsupported
+}
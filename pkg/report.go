@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runReport collects the results of the run for --report, every row of the CSV log goes through add.
+type runReport struct {
+	mu          sync.Mutex
+	RunID       string                  `json:"run_id"` // the runId column of the CSV log
+	Started     time.Time               `json:"started"`
+	Finished    time.Time               `json:"finished"`
+	Status      string                  `json:"status"` // completed, failed or interrupted
+	Statuses    map[string]int          `json:"statuses"`
+	Total       *reportCount            `json:"total"`
+	Categories  map[string]*reportCount `json:"categories"`
+	Extensions  map[string]*reportCount `json:"extensions"`
+	DateSources map[string]int          `json:"date_sources"`
+	Duplicates  []reportDuplicate       `json:"duplicates"`
+	Skipped     []reportSkip            `json:"skipped"`
+	Errors      []reportError           `json:"errors"`
+	Resumed     int                     `json:"resumed"`    // files the --resume log had as copied
+	Mismatches  int64                   `json:"mismatches"` // files whose sniffed content disagrees with their extension
+	Phases      []reportPhase           `json:"phases"`
+	Config      reportConfig            `json:"config"`
+}
+
+// reportCount sums up the files of a category or extension which were copied, or planned with --dry-run.
+type reportCount struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// reportDuplicate is a file whose destination name was already taken, Conflict tells what --on-conflict did.
+type reportDuplicate struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Conflict    string `json:"conflict"`
+}
+
+type reportSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type reportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// reportPhase is how long a step of the run took.
+type reportPhase struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// reportConfig is the configuration the run actually used, after defaults and the rules file were applied.
+type reportConfig struct {
+	Flags        Flags               `json:"flags"`
+	Rules        map[string][]string `json:"rules"` // [categories]extensions
+	NameContains map[string][]string `json:"name_contains,omitempty"`
+	Priority     []string            `json:"priority_order,omitempty"`
+	Sort         map[string]string   `json:"sort,omitempty"`
+	DateSources  map[string][]string `json:"date_sources,omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		Started:     time.Now(),
+		Statuses:    make(map[string]int),
+		Total:       &reportCount{},
+		Categories:  make(map[string]*reportCount),
+		Extensions:  make(map[string]*reportCount),
+		DateSources: make(map[string]int),
+		Duplicates:  make([]reportDuplicate, 0),
+		Skipped:     make([]reportSkip, 0),
+		Errors:      make([]reportError, 0),
+		Phases:      make([]reportPhase, 0),
+	}
+}
+
+// add counts a row of the CSV log, ext is the extension its rule was matched with.
+func (r *runReport) add(e LogEntry, ext string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Statuses[e.Status]++
+	if e.Conflict != "" && e.Conflict != noConflict {
+		r.Duplicates = append(r.Duplicates, reportDuplicate{Source: e.Source, Destination: e.Destination, Conflict: e.Conflict})
+	}
+	switch e.Status {
+	case statusSkipped, statusDuplicate:
+		reason := e.Conflict
+		if reason == "" {
+			reason = e.Reason
+		}
+		r.Skipped = append(r.Skipped, reportSkip{Path: e.Source, Reason: reason})
+		return
+	case statusSuccess, statusPlanned:
+	default:
+		return
+	}
+	count := func(counts map[string]*reportCount, key string) {
+		c, exists := counts[key]
+		if !exists {
+			c = &reportCount{}
+			counts[key] = c
+		}
+		c.Files++
+		c.Bytes += e.Size
+	}
+	count(r.Categories, e.Category)
+	count(r.Extensions, strings.ToLower(ext))
+	r.Total.Files++
+	r.Total.Bytes += e.Size
+	if e.DateSource != "" {
+		r.DateSources[e.DateSource]++
+	}
+}
+
+// counts returns how many rows were counted by status and the bytes of the copied files.
+func (r *runReport) counts() (map[string]int, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.Statuses), r.Total.Bytes
+}
+
+// phase records that the step name took from start until now.
+func (r *runReport) phase(name string, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Phases = append(r.Phases, reportPhase{Name: name, Seconds: time.Since(start).Seconds()})
+}
+
+// WriteReport finishes the report of the run and writes it as JSON to reportPath.
+func (o *Operator) WriteReport(reportPath string) error {
+	r := o.report
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	r.Phases = append(r.Phases, reportPhase{Name: "total", Seconds: r.Finished.Sub(r.Started).Seconds()})
+	r.RunID = o.RunID
+	r.Status = o.runStatus()
+	for _, e := range o.Errors() {
+		r.Errors = append(r.Errors, reportError{Path: e.Path, Error: e.Err.Error()})
+	}
+	r.Resumed = o.ResumedCount
+	r.Mismatches = o.mismatches.Load()
+	// the pool sizes the run picked on its own are reported instead of 0.
+	flags := o.Flags
+	if o.workers != nil {
+		flags.Workers = cap(o.workers)
+		flags.MaxOpenFiles = cap(o.copySlots) * openFilesPerCopy
+	}
+	r.Config = reportConfig{
+		Flags:        flags,
+		Rules:        o.Storage.Categories,
+		NameContains: o.Storage.NameContains,
+		Priority:     o.Storage.Priority,
+		Sort:         o.Storage.SortMap,
+		DateSources:  o.Storage.DateSources,
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(reportPath, append(data, '\n'), 0o666); err != nil {
+		return fmt.Errorf("failed to write report:%s:%w", reportPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_WriteReport(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(src, "sub"), 0o755))
+	for name, content := range map[string]string{"a.jpg": "jpg", "sub/a.jpg": "other jpg", "b.pdf": "pdf", "c.txt": ""} {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte(content), 0o644))
+	}
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, OnConflict: ConflictSuffix}
+	rules := []Rule{{Category: "images", Extensions: []string{"jpg"}}, {Category: "documents", Extensions: []string{"pdf"}}}
+	o.BuildStorageMaps(&Config{Rules: rules})
+	require.NoError(t, o.CreateSubdirs(dst, rules))
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+
+	reportPath := path.Join(t.TempDir(), "report.json")
+	require.NoError(t, o.WriteReport(reportPath))
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	var report runReport
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	assert.Equal(t, "completed", report.Status)
+	assert.Equal(t, o.RunID, report.RunID)
+	assert.Equal(t, map[string]int{"SUCCESS": 3, "SKIPPED": 1}, report.Statuses)
+	assert.Equal(t, &reportCount{Files: 3, Bytes: 15}, report.Total)
+	assert.Equal(t, &reportCount{Files: 2, Bytes: 12}, report.Categories["images"])
+	assert.Equal(t, &reportCount{Files: 2, Bytes: 12}, report.Extensions["jpg"])
+	require.Len(t, report.Duplicates, 1)
+	assert.Equal(t, path.Join(dst, "images", "a_1.jpg"), report.Duplicates[0].Destination)
+	assert.Equal(t, []reportSkip{{Path: path.Join(src, "c.txt"), Reason: "has size 0"}}, report.Skipped)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, []string{"setup", "prescan", "process", "total"}, phaseNames(report.Phases))
+	assert.Equal(t, []string{"jpg"}, report.Config.Rules["images"])
+	assert.Positive(t, report.Config.Flags.Workers, "the effective worker count is reported")
+}
+
+func phaseNames(phases []reportPhase) []string {
+	names := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+	}
+	return names
+}
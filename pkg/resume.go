@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+)
+
+// readLog reads a CSV log written by CSVLogger and returns its rows as [column]value maps.
+// Rows are matched by header name, so logs of older versions with fewer columns still work.
+func readLog(logPath string) ([]map[string]string, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}(f)
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of log %s: %w", logPath, err)
+	}
+
+	rows := make([]map[string]string, 0)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log %s: %w", logPath, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// LoadResume reads the log of an interrupted run and remembers every source it copied successfully.
+func (o *Operator) LoadResume(logPath string) error {
+	rows, err := readLog(logPath)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row["status"] != statusSuccess || row["sourceFilePath"] == "" || row["destinationFilePath"] == "" {
+			continue
+		}
+		o.resumed[row["sourceFilePath"]] = row["destinationFilePath"]
+	}
+	slog.Info("resuming run", "log", logPath, "copied files", len(o.resumed))
+	return nil
+}
+
+// alreadyDone reports whether the resumed run copied fp successfully and its destination
+// still exists with the same size as fp, such files aren't copied again.
+// An incomplete destination is replaced by the new copy, see resolveConflict.
+func (o *Operator) alreadyDone(fp string) bool {
+	dst, exists := o.resumed[fp]
+	if !exists {
+		return false
+	}
+	srcInfo, err := os.Stat(fp)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil || dstInfo.Size() != srcInfo.Size() {
+		slog.Warn("destination of resumed run is missing or incomplete, copying it again", "source", fp, "destination", dst)
+		return false
+	}
+	slog.Debug("already copied by resumed run", "source", fp, "destination", dst)
+	o.ResumedCount++
+	o.logResult(LogEntry{Status: statusSkipped, Source: fp, Destination: dst, FileName: path.Base(fp),
+		Reason: "already copied by the resumed run", Size: srcInfo.Size()})
+	return true
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_LoadResume(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"intact.jpg", "missing.jpg", "truncated.jpg"} {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte("jpg content"), 0o644))
+	}
+	logPath := path.Join(t.TempDir(), "run.csv")
+	first := runLogged(t, src, dst, logPath, ModeCopy)
+	require.NoError(t, os.Remove(path.Join(dst, "images", "missing.jpg")))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "truncated.jpg"), []byte("jpg"), 0o644))
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, LogPath: logPath, Resume: logPath, OnConflict: ConflictSuffix}
+	o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+	require.NoError(t, o.LoadResume(logPath))
+	assert.Len(t, o.resumed, 3)
+	o.CsvHandler, err = NewCSVLogger(logPath, true)
+	require.NoError(t, err)
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, o.CsvHandler.Close())
+
+	assert.Equal(t, 1, o.ResumedCount)
+	for _, name := range []string{"missing.jpg", "truncated.jpg"} {
+		content, err := os.ReadFile(path.Join(dst, "images", name))
+		require.NoError(t, err, name)
+		assert.Equal(t, "jpg content", string(content), "%s is copied again", name)
+	}
+	assert.NoFileExists(t, path.Join(dst, "images", "truncated_1.jpg"), "the incomplete copy is replaced, not suffixed")
+
+	rows, err := readLog(logPath)
+	require.NoError(t, err)
+	require.Len(t, rows, 6, "the resumed run appends to the same log")
+	statuses, conflicts := make(map[string]string), make(map[string]string)
+	for _, row := range rows[:3] {
+		assert.Equal(t, first, row["runId"])
+	}
+	for _, row := range rows[3:] {
+		assert.Equal(t, o.RunID, row["runId"])
+		statuses[row["fileName"]] = row["status"]
+		conflicts[row["fileName"]] = row["conflict"]
+	}
+	assert.Equal(t, "overwrite: incomplete copy of the resumed run", conflicts["truncated.jpg"])
+	assert.Equal(t, map[string]string{"intact.jpg": statusSkipped, "missing.jpg": statusSuccess, "truncated.jpg": statusSuccess}, statuses)
+}
@@ -1,8 +1,11 @@
 package pkg
 
 import (
+	"fmt"
 	"go.yaml.in/yaml/v4"
 	"os"
+	"slices"
+	"strings"
 )
 
 type Rule struct {
@@ -10,7 +13,13 @@ type Rule struct {
 	Separate     []string `yaml:"separate"`
 	Extensions   []string `yaml:"extensions,omitempty"`
 	NameContains []string `yaml:"name_contains,omitempty"`
-	Sort         string   `yaml:"sort,omitempty"` // month&year is only possible options
+	Sort         string   `yaml:"sort,omitempty"`         // month&year is only possible options
+	DateSources  []string `yaml:"date_sources,omitempty"` // tried in order for sort, e.g. exif:DateTimeOriginal, filename, mtime
+	// SniffOverride lets the file header sniffed with --sniff win over the extension: the rule takes files whose
+	// content is one of its extensions whatever their name, and leaves files whose content is another format.
+	SniffOverride bool `yaml:"sniff_override,omitempty"`
+	// CaseSensitive makes the rule match its extensions exactly, by default "jpg" matches "JPG" too.
+	CaseSensitive bool `yaml:"case_sensitive,omitempty"`
 }
 
 type Override struct {
@@ -32,10 +41,84 @@ func ReadCategories(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
 
 	return &cfg, nil
 }
 
+// Validate checks the options of every rule which the yaml types can't.
+func (c *Config) Validate() error {
+	for _, rule := range c.Rules {
+		if rule.Sort != "" && rule.Sort != "month" && rule.Sort != "year" {
+			return fmt.Errorf("rule %s: sort must be 'month' or 'year', not %q", rule.Category, rule.Sort)
+		}
+		for _, source := range rule.DateSources {
+			if !validDateSource(source) {
+				return fmt.Errorf("rule %s: unknown date source %q, must be exif:<Tag>, filename or mtime", rule.Category, source)
+			}
+		}
+	}
+	return nil
+}
+
+// sameExtension reports whether a of rule r and b of rule other are the same extension for matching.
+func (r Rule) sameExtension(other Rule, a, b string) bool {
+	if r.CaseSensitive && other.CaseSensitive {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
+}
+
+// duplicateExtensions returns the extensions listed by more than one rule, in lower case, with the categories of those rules.
+func (c *Config) duplicateExtensions() map[string][]string {
+	type listing struct {
+		rule Rule
+		ext  string
+	}
+	byName := make(map[string][]listing)
+	for _, rule := range c.Rules {
+		for _, ext := range rule.Extensions {
+			key := strings.ToLower(ext)
+			byName[key] = append(byName[key], listing{rule: rule, ext: ext})
+		}
+	}
+
+	duplicates := make(map[string][]string)
+	for key, listings := range byName {
+		for i, a := range listings {
+			for _, b := range listings[i+1:] {
+				if a.rule.Category == b.rule.Category || !a.rule.sameExtension(b.rule, a.ext, b.ext) {
+					continue
+				}
+				for _, category := range []string{a.rule.Category, b.rule.Category} {
+					if !slices.Contains(duplicates[key], category) {
+						duplicates[key] = append(duplicates[key], category)
+					}
+				}
+			}
+		}
+	}
+	return duplicates
+}
+
 func (r Rule) SeparateExists() bool {
 	return len(r.Separate) > 0
 }
+
+var (
+	imageExtensions = []string{"jpg", "jpeg", "png", "webp", "heic", "heif", "tif", "tiff", "dng", "cr2", "nef", "arw", "gif"}
+	videoExtensions = []string{"mp4", "mov", "m4v", "avi", "mkv", "3gp", "mts", "webm"}
+)
+
+// MediaRules returns the fixed rule set of the sort-img subcommand:
+// images and videos, both sorted into date directories by sortType, dated by dateSources.
+func MediaRules(sortType string, dateSources []string) *Config {
+	return &Config{
+		Rules: []Rule{
+			{Category: "images", Extensions: imageExtensions, Sort: sortType, DateSources: dateSources},
+			{Category: "videos", Extensions: videoExtensions, Sort: sortType, DateSources: dateSources},
+		},
+	}
+}
@@ -0,0 +1,22 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_duplicateExtensions(t *testing.T) {
+	c := &Config{Rules: []Rule{
+		{Category: "images", Extensions: []string{"jpg", "png", "gif"}},
+		{Category: "videos", Extensions: []string{"mp4", "GIF"}},
+		{Category: "raw", Extensions: []string{"PNG"}, CaseSensitive: true},
+		{Category: "scans", Extensions: []string{"png"}, CaseSensitive: true},
+		{Category: "special", NameContains: []string{"user1234"}},
+	}}
+	assert.Equal(t, map[string][]string{
+		"gif": {"images", "videos"},
+		"png": {"images", "raw", "scans"},
+	}, c.duplicateExtensions())
+
+	assert.Empty(t, MediaRules("month", defaultDateSources).duplicateExtensions())
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ExitInterrupted is the exit code of runs stopped by a signal, like shells use for SIGINT.
+const ExitInterrupted = 130
+
+// InterruptContext returns a context which is cancelled by the first SIGINT or SIGTERM, so the run can stop
+// cleanly. A second signal exits at once. stop releases the signals again.
+func InterruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("interrupted, stopping after cleaning up the files in flight. interrupt again to exit at once", "signal", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			slog.Error("interrupted twice, exiting at once", "signal", sig)
+			os.Exit(ExitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// ctxReader stops reading once ctx is cancelled, so long copies can be interrupted in between two reads.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	kindImage      = "image"
+	kindVideo      = "video"
+	kindAudio      = "audio"
+	kindArchive    = "archive"
+	kindDocument   = "document"
+	kindExecutable = "executable"
+)
+
+// sniffHeaderSize is how much of a file is read to sniff it, enough for the tar magic at 257.
+const sniffHeaderSize = 512
+
+// signature is a magic number at offset, Exts are the extensions files with it may have, the first one is the usual one.
+// ftyp and RIFF containers are told apart by the bytes at 8.
+type signature struct {
+	Kind   string
+	Exts   []string
+	offset int
+	magic  []byte
+	sub    []byte // second magic at 8, for RIFF and FORM containers
+}
+
+// zipExts are the formats which are zip archives inside.
+var zipExts = []string{"zip", "docx", "xlsx", "pptx", "dotx", "odt", "ods", "odp", "epub", "jar", "apk", "whl", "msix", "xpi", "kmz", "cbz"}
+
+var signatures = []signature{
+	{Kind: kindImage, Exts: []string{"jpg", "jpeg", "jfif", "jpe"}, magic: []byte{0xff, 0xd8, 0xff}},
+	{Kind: kindImage, Exts: []string{"png"}, magic: pngSignature},
+	{Kind: kindImage, Exts: []string{"gif"}, magic: []byte("GIF87a")},
+	{Kind: kindImage, Exts: []string{"gif"}, magic: []byte("GIF89a")},
+	{Kind: kindImage, Exts: []string{"webp"}, magic: []byte("RIFF"), sub: []byte("WEBP")},
+	{Kind: kindImage, Exts: []string{"tif", "tiff", "dng", "cr2", "nef", "arw"}, magic: []byte("II*\x00")},
+	{Kind: kindImage, Exts: []string{"tif", "tiff", "dng", "nef"}, magic: []byte("MM\x00*")},
+	{Kind: kindImage, Exts: []string{"psd"}, magic: []byte("8BPS")},
+
+	{Kind: kindVideo, Exts: []string{"avi"}, magic: []byte("RIFF"), sub: []byte("AVI ")},
+	{Kind: kindVideo, Exts: []string{"mkv", "webm"}, magic: []byte{0x1a, 0x45, 0xdf, 0xa3}},
+	{Kind: kindVideo, Exts: []string{"mpeg", "mpg"}, magic: []byte{0x00, 0x00, 0x01, 0xba}},
+	{Kind: kindVideo, Exts: []string{"mpeg", "mpg"}, magic: []byte{0x00, 0x00, 0x01, 0xb3}},
+	{Kind: kindVideo, Exts: []string{"flv"}, magic: []byte("FLV")},
+
+	{Kind: kindAudio, Exts: []string{"mp3"}, magic: []byte("ID3")},
+	{Kind: kindAudio, Exts: []string{"mp3"}, magic: []byte{0xff, 0xfb}},
+	{Kind: kindAudio, Exts: []string{"flac"}, magic: []byte("fLaC")},
+	{Kind: kindAudio, Exts: []string{"ogg", "oga", "ogv", "opus"}, magic: []byte("OggS")},
+	{Kind: kindAudio, Exts: []string{"wav"}, magic: []byte("RIFF"), sub: []byte("WAVE")},
+	{Kind: kindAudio, Exts: []string{"aif", "aiff"}, magic: []byte("FORM"), sub: []byte("AIFF")},
+
+	{Kind: kindArchive, Exts: zipExts, magic: []byte("PK\x03\x04")},
+	{Kind: kindArchive, Exts: zipExts, magic: []byte("PK\x05\x06")},
+	{Kind: kindArchive, Exts: []string{"rar"}, magic: []byte("Rar!\x1a\x07")},
+	{Kind: kindArchive, Exts: []string{"7z", "7z.001"}, magic: []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{Kind: kindArchive, Exts: []string{"gz", "tgz", "tar.gz"}, magic: []byte{0x1f, 0x8b}},
+	{Kind: kindArchive, Exts: []string{"bz2", "tbz2", "tar.bz2"}, magic: []byte("BZh")},
+	{Kind: kindArchive, Exts: []string{"xz", "txz", "tar.xz"}, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Kind: kindArchive, Exts: []string{"zst", "tar.zst"}, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Kind: kindArchive, Exts: []string{"tar"}, offset: 257, magic: []byte("ustar")},
+
+	{Kind: kindDocument, Exts: []string{"pdf"}, magic: []byte("%PDF-")},
+	{Kind: kindDocument, Exts: []string{"doc", "xls", "ppt", "msi", "msg"}, magic: []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}},
+	{Kind: kindDocument, Exts: []string{"rtf"}, magic: []byte(`{\rtf`)},
+	{Kind: kindDocument, Exts: []string{"ps", "eps"}, magic: []byte("%!PS")},
+
+	{Kind: kindExecutable, Exts: []string{"elf", "so", "o"}, magic: []byte("\x7fELF")},
+	{Kind: kindExecutable, Exts: []string{"exe", "dll", "sys", "scr"}, magic: []byte("MZ")},
+	{Kind: kindExecutable, Exts: []string{"macho", "dylib"}, magic: []byte{0xcf, 0xfa, 0xed, 0xfe}},
+	{Kind: kindExecutable, Exts: []string{"macho", "dylib"}, magic: []byte{0xce, 0xfa, 0xed, 0xfe}},
+	{Kind: kindExecutable, Exts: []string{"wasm"}, magic: []byte("\x00asm")},
+	{Kind: kindExecutable, Exts: []string{"sh", "bash", "py", "pl", "rb"}, magic: []byte("#!")},
+}
+
+// ftypBrands tells the ISO base media formats apart by their major brand, other brands are mp4.
+var ftypBrands = map[string]signature{
+	"qt  ": {Kind: kindVideo, Exts: []string{"mov", "qt"}},
+	"heic": {Kind: kindImage, Exts: []string{"heic", "heif"}},
+	"heix": {Kind: kindImage, Exts: []string{"heic", "heif"}},
+	"mif1": {Kind: kindImage, Exts: []string{"heif", "heic"}},
+	"avif": {Kind: kindImage, Exts: []string{"avif"}},
+	"M4A ": {Kind: kindAudio, Exts: []string{"m4a"}},
+	"3gp4": {Kind: kindVideo, Exts: []string{"3gp"}},
+	"3gp5": {Kind: kindVideo, Exts: []string{"3gp"}},
+}
+
+var mp4Signature = signature{Kind: kindVideo, Exts: []string{"mp4", "m4v", "mov", "3gp"}}
+
+// Ext is the usual extension of the sniffed format, used to match it against the extensions of the rules.
+func (s signature) Ext() string {
+	return s.Exts[0]
+}
+
+// agrees reports whether files of this format may have the extension ext.
+func (s signature) agrees(ext string) bool {
+	return slices.Contains(s.Exts, strings.ToLower(ext))
+}
+
+// sniffFile reads the header of fp and returns the format of its signature, false if it has none we know.
+func sniffFile(fp string) (signature, bool) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return signature{}, false
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	header := make([]byte, sniffHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && n == 0 {
+		return signature{}, false
+	}
+	return sniff(header[:n])
+}
+
+// sniff returns the format header starts with, false if it has no signature we know.
+func sniff(header []byte) (signature, bool) {
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		if s, known := ftypBrands[string(header[8:12])]; known {
+			return s, true
+		}
+		return mp4Signature, true
+	}
+	for _, s := range signatures {
+		if len(header) < s.offset+len(s.magic) || !bytes.Equal(header[s.offset:s.offset+len(s.magic)], s.magic) {
+			continue
+		}
+		if s.sub != nil && (len(header) < 12 || !bytes.Equal(header[8:12], s.sub)) {
+			continue
+		}
+		return s, true
+	}
+	return signature{}, false
+}
@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_sniff(t *testing.T) {
+	tar := make([]byte, 300)
+	copy(tar[257:], "ustar")
+	tests := []struct {
+		header []byte
+		ext    string
+		kind   string
+	}{
+		{[]byte{0xff, 0xd8, 0xff, 0xe0}, "jpg", kindImage},
+		{[]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "webp", kindImage},
+		{[]byte("RIFF\x00\x00\x00\x00WAVEfmt "), "wav", kindAudio},
+		{[]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "heic", kindImage},
+		{[]byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "mp4", kindVideo},
+		{[]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "mov", kindVideo},
+		{[]byte("PK\x03\x04\x14\x00"), "zip", kindArchive},
+		{tar, "tar", kindArchive},
+		{[]byte("%PDF-1.7\n"), "pdf", kindDocument},
+		{[]byte("MZ\x90\x00"), "exe", kindExecutable},
+	}
+	for _, test := range tests {
+		s, known := sniff(test.header)
+		if assert.True(t, known, test.ext) {
+			assert.Equal(t, test.ext, s.Ext())
+			assert.Equal(t, test.kind, s.Kind)
+		}
+	}
+
+	_, known := sniff([]byte("just some text"))
+	assert.False(t, known)
+
+	s, _ := sniff([]byte("PK\x03\x04"))
+	assert.True(t, s.agrees("DOCX"), "office files are zip archives")
+	assert.False(t, s.agrees("pdf"))
+}
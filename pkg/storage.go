@@ -1,18 +1,19 @@
 package pkg
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/barasher/go-exiftool"
-	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
-	"syscall"
+	"time"
 )
 
 const (
@@ -22,43 +23,72 @@ const (
 type Storage struct {
 	Entries        []os.DirEntry
 	Categories     map[string][]string // [categories][]extensions
-	Extensions     map[string]string   // [extensions][categories]
+	NameContains   map[string][]string // [categories][]substrings
+	RuleOrder      []string            // categories in the order of the rules file
+	Priority       []string            // categories of override.priority_order
 	OutDirectories map[string][]string // []categories[files]
 	SubDirs        map[string][]string // [subDir][]extensions
 	Unprocessed    []string
-	SortMap        map[string]string //image:year, videos:month, documents:month
-	Exif           *exiftool.Exiftool
+	SortMap        map[string]string   //image:year, videos:month, documents:month
+	DateSources    map[string][]string // [categories]date sources for sort, see defaultDateSources
+	SniffOverride  map[string]bool     // [categories] rules whose sniffed content wins over the extension
+	CaseSensitive  map[string]bool     // [categories] rules matching their extensions exactly
+	Exif           *ExifPool
 }
 
 func NewStorage() *Storage {
 	return &Storage{
 		Categories:     make(map[string][]string),
-		Extensions:     make(map[string]string),
+		NameContains:   make(map[string][]string),
+		RuleOrder:      make([]string, 0),
 		OutDirectories: make(map[string][]string),
 		SubDirs:        make(map[string][]string),
 		Unprocessed:    make([]string, 0),
 		SortMap:        make(map[string]string),
+		DateSources:    make(map[string][]string),
+		SniffOverride:  make(map[string]bool),
+		CaseSensitive:  make(map[string]bool),
 	}
 }
 
 type Operator struct {
 	Storage        Storage
 	Flags          Flags
+	Pattern        *DatePattern // compiled --pattern, nil if not set
 	CsvHandler     *CSVLogger
 	SubDirCount    int
 	ExtensionCount int
-	sem            chan struct{}
-	once           sync.Once
+	RunID          string        // identifies the rows of this run in the CSV log, see newRunID
+	ResumedCount   int           // files skipped since the --resume log has them as copied
+	workers        chan struct{} // async traversal slots, see initPool
+	copySlots      chan struct{} // copies which may have their files open at once
 	mu             sync.Mutex
+	errMu          sync.Mutex
+	errs           []FileError        // failures of the run, see fail
+	failedDirs     map[string]bool    // directories which couldn't be read, see failDir
+	cancel         context.CancelFunc // cancels the run, for --fail-fast
+	interrupted    bool
+	failures       atomic.Int64
+	mismatches     atomic.Int64         // files whose sniffed content disagrees with their extension
+	reserved       map[string]string    // [destination]source of the paths handed out during this run
+	resumed        map[string]string    // [source]destination copied by the run given with --resume
+	plan           map[string]*planStat // [category] planned copies, dry-run only
+	cache          *metadataCache
+	progress       *progress
+	report         *runReport
+	exifOnce       sync.Once
+	exifErr        error
 }
 
-func (o *Operator) initPool(n int) {
-	o.once.Do(func() {
-		if n <= 0 {
-			n = 8
-		}
-		o.sem = make(chan struct{}, n)
-	})
+// Failures returns how many files failed during the run, e.g. because their validation didn't match.
+func (o *Operator) Failures() int64 {
+	return o.failures.Load()
+}
+
+// planStat sums up the planned copies of a single category during dry-run.
+type planStat struct {
+	Files int
+	Bytes int64
 }
 
 func GetNewOperator() (*Operator, error) {
@@ -68,16 +98,13 @@ func GetNewOperator() (*Operator, error) {
 		CsvHandler:     nil,
 		SubDirCount:    0,
 		ExtensionCount: 0,
-		sem:            nil,
-		once:           sync.Once{},
 		mu:             sync.Mutex{},
-	}
-	o.initPool(8)
-
-	var err error
-	o.Storage.Exif, err = initExifTool()
-	if err != nil {
-		return nil, err
+		reserved:       make(map[string]string),
+		resumed:        make(map[string]string),
+		plan:           make(map[string]*planStat),
+		RunID:          newRunID(),
+		progress:       newProgress(),
+		report:         newRunReport(),
 	}
 	return o, nil
 }
@@ -85,23 +112,47 @@ func GetNewOperator() (*Operator, error) {
 func (o *Operator) BuildStorageMaps(c *Config) {
 	for _, rule := range c.Rules {
 		o.Storage.Categories[rule.Category] = make([]string, 0)
+		o.Storage.RuleOrder = append(o.Storage.RuleOrder, rule.Category)
+		if len(rule.NameContains) > 0 {
+			o.Storage.NameContains[rule.Category] = append(o.Storage.NameContains[rule.Category], rule.NameContains...)
+		}
 		for _, extension := range rule.Extensions {
 			o.Storage.Categories[rule.Category] = append(o.Storage.Categories[rule.Category], extension)
-			o.Storage.Extensions[extension] = rule.Category
+		}
+		if rule.SniffOverride {
+			o.Storage.SniffOverride[rule.Category] = true
+		}
+		if rule.CaseSensitive {
+			o.Storage.CaseSensitive[rule.Category] = true
 		}
 		if rule.SeparateExists() {
 			o.Storage.SubDirs[rule.Category] = append(o.Storage.SubDirs[rule.Category], rule.Separate...)
 		}
 		if rule.Sort != "" {
 			o.Storage.SortMap[rule.Category] = rule.Sort
+			if len(rule.DateSources) > 0 {
+				o.Storage.DateSources[rule.Category] = rule.DateSources
+			}
+		}
+	}
+	duplicates := c.duplicateExtensions()
+	for _, ext := range slices.Sorted(maps.Keys(duplicates)) {
+		slog.Warn("extension is listed by several rules, see priority_order to choose between them",
+			"extension", ext, "categories", duplicates[ext])
+	}
+	for _, category := range c.Override.Priority {
+		if _, exists := o.Storage.Categories[category]; !exists {
+			slog.Warn("priority_order lists a category without a rule, ignoring it", "category", category)
+			continue
 		}
+		o.Storage.Priority = append(o.Storage.Priority, category)
 	}
 }
 
 func (o *Operator) GetSeparateSubdirs(category, ext string) string {
 	if subdirs, exists := o.Storage.SubDirs[category]; exists {
 		for _, sub := range subdirs {
-			if sub == ext {
+			if o.sameExtension(category, sub, ext) {
 				return sub
 			}
 		}
@@ -117,23 +168,63 @@ func (o *Operator) GetSortSubDirs(category string) (string, bool) {
 	return "", false
 }
 
+// GetExtensionCategory returns the first rule listing ext, see hasExtension.
 func (o *Operator) GetExtensionCategory(ext string) (string, bool) {
-	if val, ok := o.Storage.Extensions[ext]; ok {
-		return val, true
+	for _, category := range o.Storage.RuleOrder {
+		if o.hasExtension(category, ext) {
+			return category, true
+		}
 	}
 	return unknown, false
 }
 
-// AddType adds and returns category of the file
-func (o *Operator) AddType(ext, fp string) string {
-	category, exists := o.GetExtensionCategory(ext)
-	if !exists {
-		slog.Warn("unknown extension, doesn't match to rules", "extension", ext)
+// sameExtension reports whether a and b are the same extension for the rule of category,
+// extensions ignore case unless the rule is case_sensitive.
+func (o *Operator) sameExtension(category, a, b string) bool {
+	if o.Storage.CaseSensitive[category] {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
+}
+
+// hasExtension reports whether the rule of category lists ext.
+func (o *Operator) hasExtension(category, ext string) bool {
+	return ext != "" && slices.ContainsFunc(o.Storage.Categories[category], func(listed string) bool {
+		return o.sameExtension(category, listed, ext)
+	})
+}
+
+// fileExtension returns the extension of fp rules are matched with: the longest one any rule lists,
+// e.g. "tar.gz" over "gz" for backup.tar.gz, or its last one if no rule lists any.
+func (o *Operator) fileExtension(fp string) string {
+	for _, candidate := range extensionCandidates(fp) {
+		if _, known := o.GetExtensionCategory(candidate); known {
+			return candidate
+		}
+	}
+	return extension(fp)
+}
+
+// AddType adds the file to its category and returns the rule match which decided it.
+// see pickMatch for the order used when several rules match.
+func (o *Operator) AddType(ext, fp string) Match {
+	return o.addMatch(o.classify(ext, fp), fp)
+}
+
+// addMatch adds the file to the category of match, extension and content disagreements are reported here.
+func (o *Operator) addMatch(match Match, fp string) Match {
+	if match.mismatch {
+		slog.Warn("extension doesn't match the content", "path", fp, "content", match.Content, "kind", match.contentKind,
+			"category", match.Category)
+		o.mismatches.Add(1)
+	}
+	if match.Category == unknown && match.Criterion == "" {
+		slog.Warn("unknown extension, doesn't match to rules", "extension", extension(fp))
 		slog.Warn("copying to the unknown dir", "filepath", fp)
-		return unknown
+		return match
 	}
-	o.Storage.OutDirectories[category] = append(o.Storage.OutDirectories[category], fp)
-	return category
+	o.Storage.OutDirectories[match.Category] = append(o.Storage.OutDirectories[match.Category], fp)
+	return match
 }
 
 func (o *Operator) CreateSubdirs(dstBasePath string, rules []Rule) error {
@@ -145,13 +236,14 @@ func (o *Operator) CreateSubdirs(dstBasePath string, rules []Rule) error {
 		return err
 	}
 
+	// existing directories are fine, re-runs into the same destination are resolved by the --on-conflict policy.
 	for _, rule := range rules {
-		if err := os.Mkdir(path.Join(dstBasePath, rule.Category), syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY); err != nil {
+		if err := os.Mkdir(path.Join(dstBasePath, rule.Category), dirPerm); err != nil && !os.IsExist(err) {
 			return err
 		}
 		if rule.SeparateExists() {
 			for _, separateDir := range rule.Separate {
-				if err := os.Mkdir(path.Join(dstBasePath, rule.Category, separateDir), syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY); err != nil {
+				if err := os.Mkdir(path.Join(dstBasePath, rule.Category, separateDir), dirPerm); err != nil && !os.IsExist(err) {
 					return err
 				}
 			}
@@ -162,49 +254,119 @@ func (o *Operator) CreateSubdirs(dstBasePath string, rules []Rule) error {
 
 // uniqueDstPath has two tasks.
 // original task: if there's two file with same name, to not overwriting, add an '_' and number depending on how many copies do exist.
+// what happens on such a conflict is now up to the --on-conflict policy, see resolveConflict.
 // task that got added during sort-image-files, which will be refactored and improved,
 // is to create YEAR, and YEAR/MONTH directories if they don't exist. Q: why is it done here currently?
-// because getFileDate function returns the format as in YEAR-MONTH and
-func uniqueDstPath(dstBasePath, dstDir, specialDir, baseName string) string {
-	ext := filepath.Ext(baseName)
-	base := strings.TrimSuffix(baseName, ext)
+// because fileDate and formatPeriod return the format as in YEAR-MONTH and
+// Every returned path is reserved for the rest of the run, so dry-run plans and
+// concurrent async copies can't be handed out the same name twice.
+// A destination which can't be checked, e.g. since its directory is a file, is returned as error.
+func (o *Operator) uniqueDstPath(dstBasePath, dstDir, specialDir, src string, srcInfo os.FileInfo) (conflictDecision, error) {
+	baseName := path.Base(src)
 	dstNewPath := path.Join(dstBasePath, dstDir, baseName)
 	if specialDir != "" {
 		dstNewPath = path.Join(dstBasePath, dstDir, specialDir, baseName)
 		// create the specialDir if it doesn't exist. this is only required for year/month sort things.
-		if err := createDirectory(path.Join(dstBasePath, dstDir, specialDir)); err != nil {
-			panic(err)
+		if !o.Flags.DryRun {
+			if err := createDirectory(path.Join(dstBasePath, dstDir, specialDir)); err != nil {
+				return conflictDecision{}, err
+			}
+		}
+	}
+	var hashes candidateHashes
+	if o.Flags.OnConflict == ConflictSkipIdentical {
+		var err error
+		if hashes, err = o.hashCandidates(dstNewPath, src, srcInfo); err != nil {
+			return conflictDecision{}, err
 		}
 	}
 
-	// TODO: improve this following idiotic logic
-	original := dstNewPath
-	i := 1
-	for {
-		if _, err := os.Stat(dstNewPath); err != nil {
-			if os.IsNotExist(err) {
-				break
-			}
-			slog.Error("stat call failed during trying to create a unique destination path", "PATH:", dstNewPath)
-			panic(err)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	decision := conflictDecision{Path: dstNewPath, Reason: noConflict}
+	taken, err := o.dstTaken(dstNewPath)
+	if err != nil {
+		return conflictDecision{}, err
+	}
+	if taken {
+		if decision, err = o.resolveConflict(dstNewPath, src, srcInfo, hashes); err != nil {
+			return conflictDecision{}, err
+		}
+	}
+	if !decision.Skip {
+		o.reserved[decision.Path] = src
+	}
+	return decision, nil
+}
+
+// suffixPath returns dst with '_i' added in front of its extension.
+func suffixPath(dst string, i int) string {
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(path.Base(dst), ext)
+	return path.Join(path.Dir(dst), fmt.Sprintf("%s_%d%s", base, i, ext))
+}
+
+// nextFreePath returns the first of dst_1, dst_2, ... which isn't taken.
+// o.mu must be held by the caller.
+func (o *Operator) nextFreePath(dst string) (string, error) {
+	dstNewPath := dst
+	for i := 1; ; i++ {
+		taken, err := o.dstTaken(dstNewPath)
+		if err != nil || !taken {
+			return dstNewPath, err
 		}
-		if specialDir == "" {
-			dstNewPath = path.Join(path.Dir(original), fmt.Sprintf("%s_%d%s", base, i, ext))
-		} else {
-			dstNewPath = path.Join(path.Dir(original), fmt.Sprintf("%s_%d%s", base, i, ext))
+		dstNewPath = suffixPath(dst, i)
+	}
+}
+
+// dstTaken reports whether dst is already reserved in this run or exists on disk.
+// A name which can't be checked, e.g. since it's too long, is returned as error.
+// o.mu must be held by the caller.
+func (o *Operator) dstTaken(dst string) (bool, error) {
+	if _, reserved := o.reserved[dst]; reserved {
+		return true, nil
+	}
+	if _, err := os.Stat(dst); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
 		}
-		i++
+		return false, fmt.Errorf("failed to check destination: %w", err)
 	}
-	return dstNewPath
+	return true, nil
 }
 
-func (o *Operator) Copy(dstPath, dstDir, specialDir, fileAbsolutePath string) error {
+// planCopy prints a single planned copy and adds it to the dry-run summary, through the progress bar if it's drawn.
+// Skipped files are only printed.
+func (o *Operator) planCopy(category, src string, size int64, decision conflictDecision) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if decision.Skip {
+		o.progress.println(fmt.Sprintf("%s -x %s (%s)", src, decision.Path, decision.Reason))
+		return
+	}
+	if decision.Reason != noConflict {
+		o.progress.println(fmt.Sprintf("%s -> %s (%s)", src, decision.Path, decision.Reason))
+	} else {
+		o.progress.println(fmt.Sprintf("%s -> %s", src, decision.Path))
+	}
+	stat, exists := o.plan[category]
+	if !exists {
+		stat = &planStat{}
+		o.plan[category] = stat
+	}
+	stat.Files++
+	stat.Bytes += size
+}
+
+// Copy puts the file at fileAbsolutePath into its category below dstPath, as --mode says.
+// A copy cancelled through ctx removes its partial destination and is logged as INTERRUPTED.
+func (o *Operator) Copy(ctx context.Context, dstPath string, match Match, specialDir, fileAbsolutePath string) error {
+	dstDir := match.Category
+	o.acquireCopySlot()
+	defer o.releaseCopySlot()
 	srcFile, err := os.Open(fileAbsolutePath)
 	if err != nil {
-		slog.Warn("Skipping unreadable file", "path", fileAbsolutePath, "error", err)
-		// o.Storage.Unprocessed = append(o.Storage.Unprocessed, fileAbsolutePath)
-		// TODO: why is this commented out? check out later how do we deal with this.
-		return nil
+		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer func() {
 		err := srcFile.Close()
@@ -213,34 +375,91 @@ func (o *Operator) Copy(dstPath, dstDir, specialDir, fileAbsolutePath string) er
 		}
 	}()
 
+	info, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file:%s:%w", fileAbsolutePath, err)
+	}
 	_, fileName := path.Split(fileAbsolutePath)
-	destinationFile, err := os.Create(uniqueDstPath(dstPath, dstDir, specialDir, fileName))
+	decision, err := o.uniqueDstPath(dstPath, dstDir, specialDir, fileAbsolutePath, info)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return err
 	}
-	defer func(destinationFile *os.File) {
-		err := destinationFile.Close()
-		if err != nil {
-			panic(err)
+	dst := decision.Path
+	entry := LogEntry{
+		Status:      statusSuccess,
+		Source:      srcFile.Name(),
+		Destination: dst,
+		FileName:    fileName,
+		Category:    match.Category,
+		Rule:        match.Criterion,
+		Reason:      match.Reason,
+		Operation:   o.Flags.Mode,
+		Conflict:    decision.Reason,
+		Size:        info.Size(),
+		DateSource:  match.DateSource,
+		Content:     match.Content,
+	}
+	if decision.Skip {
+		entry.Status = statusSkipped
+		entry.Operation = ""
+		if decision.Duplicate {
+			entry.Status = statusDuplicate
+			entry.Hash = decision.Hash
 		}
-	}(destinationFile)
-
-	_, err = io.Copy(destinationFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy %s file to %s: %w", srcFile.Name(), destinationFile.Name(), err)
 	}
-
-	err = destinationFile.Sync()
-	if err != nil {
-		return fmt.Errorf("failed to sync destination file:%s:%w", destinationFile.Name(), err)
+	if o.Flags.DryRun {
+		o.planCopy(dstDir, fileAbsolutePath, info.Size(), decision)
+		if !decision.Skip {
+			entry.Status = statusPlanned
+		}
+		o.report.add(entry, o.fileExtension(fileAbsolutePath))
+		o.progress.count(entry)
+		return nil
 	}
-
-	if o.CsvHandler != nil {
-		if err := o.CsvHandler.Log("SUCCESS", srcFile.Name(), fileName, destinationFile.Name()); err != nil {
-			slog.Error("failure-log", "error", err.Error())
+	if decision.Skip {
+		slog.Info("skipping file", "path", fileAbsolutePath, "conflict", decision.Reason)
+		o.logResult(entry)
+		return nil
+	}
+	// copies and moves rename over the old file, links can't replace it.
+	if decision.Overwrite && (o.Flags.Mode == ModeHardlink || o.Flags.Mode == ModeReflink) {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove destination file to overwrite it:%s:%w", dst, err)
 		}
 	}
 
+	switch o.Flags.Mode {
+	case ModeMove:
+		err = moveFile(ctx, srcFile, dst, &entry)
+	case ModeHardlink, ModeReflink:
+		err = linkFile(ctx, srcFile, dst, o.Flags.Mode, o.Flags.Validate, o.Flags.Preserve, &entry)
+	default:
+		entry.Hash, err = copyFile(ctx, srcFile, dst, o.Flags.Validate, o.Flags.Preserve)
+	}
+	if entry.Hash == "" {
+		// without --validate, the sha256 skip-identical worked out is the only one there is.
+		entry.Hash = decision.Hash
+	}
+	if dstInfo, statErr := os.Stat(dst); statErr == nil {
+		entry.ModTime = dstInfo.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+	if errors.Is(err, ErrorHashMismatch) {
+		// the bad copy never gets the destination name, --resume copies it again.
+		entry.Destination, entry.ModTime = "", ""
+		o.fail(entry, err)
+		return nil
+	} else if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// cancelled copies remove their partial destination, --resume copies them again.
+		slog.Warn("copy interrupted, removed the partial destination", "path", fileAbsolutePath)
+		entry.Status = statusInterrupted
+		entry.Destination = ""
+		o.logResult(entry)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	o.logResult(entry)
+
 	return nil
 }
 
@@ -248,109 +467,136 @@ func (o *Operator) Copy(dstPath, dstDir, specialDir, fileAbsolutePath string) er
 func (o *Operator) skipcheck(fp string) bool {
 	info, err := os.Stat(fp)
 	if err != nil {
-		slog.Warn("Skipping blocked file", "path", fp, "error", err)
-		o.Storage.Unprocessed = append(o.Storage.Unprocessed, fp)
+		o.fail(LogEntry{Source: fp, FileName: path.Base(fp)}, err)
 		return true
 	}
-	if !info.Mode().IsRegular() {
-		slog.Warn("Skipping blocked file", "path", fp, "error", "isn't a regular file")
-		o.Storage.Unprocessed = append(o.Storage.Unprocessed, fp)
-		return true
+	reason := ""
+	switch {
+	case !info.Mode().IsRegular():
+		reason = "isn't a regular file"
+	case isTemp(info.Name()):
+		reason = "is the temporary file of a copy which never finished"
+	case info.Size() == 0:
+		reason = "has size 0"
+	default:
+		return false
 	}
+	slog.Warn("Skipping blocked file", "path", fp, "error", reason)
+	o.skip(LogEntry{Source: fp, FileName: info.Name(), Reason: reason, Size: info.Size()})
+	return true
+}
 
-	if info.Size() == 0 {
-		slog.Warn("Skipping blocked file", "path", fp, "error", "has size 0")
-		o.Storage.Unprocessed = append(o.Storage.Unprocessed, fp)
-		return true
+// skip adds the file of entry to the unprocessed files and logs it as SKIPPED, Reason tells why.
+func (o *Operator) skip(entry LogEntry) {
+	o.Storage.Unprocessed = append(o.Storage.Unprocessed, entry.Source)
+	entry.Status = statusSkipped
+	o.logResult(entry)
+}
+
+// skipNonMedia skips files sort-img has no rule for, sort-img only handles images and videos.
+// With --sniff, images and videos without a media extension are taken by their content.
+func (o *Operator) skipNonMedia(match Match, fp string) bool {
+	if o.Flags.SubCommand != SortImgCmd {
+		return false
 	}
-	return false
+	if match.Criterion != "" {
+		return false
+	}
+	slog.Warn("Skipping non media file", "path", fp)
+	o.skip(LogEntry{Source: fp, FileName: path.Base(fp), Reason: "isn't an image or video", Content: match.Content,
+		Size: fileSize(fp)})
+	return true
 }
 
-func (o *Operator) getSpecialSubDirNames(typeDir, ext, fp string) (string, error) {
+// getSpecialSubDirNames returns the directory of the file below its category directory
+// and, for sort rules, the date source its date came from.
+func (o *Operator) getSpecialSubDirNames(typeDir, ext, fp string) (string, string, error) {
 	// special subDir is what you define in category as part of rules
 	specialSubDir := o.GetSeparateSubdirs(typeDir, ext)
 	// get the file date depending on sortDir=year/month and pass it to o.Copy
-	sortDir, exists := o.GetSortSubDirs(typeDir)
-	var err error
-	if exists {
-		sortDir, err = o.getFileDate(fp, sortDir)
-		// if the error is because we couldn't get exif date, then ignore the error
-		// otherwise return error.
-		if err != nil && !errors.Is(err, ErrorNoCreateDate) {
-			return "", err
-		}
-		if sortDir != "" {
-			specialSubDir = path.Join(specialSubDir, sortDir)
-		}
+	sortType, exists := o.GetSortSubDirs(typeDir)
+	if !exists {
+		return specialSubDir, "", nil
 	}
-	return specialSubDir, nil
+
+	sources, exists := o.Storage.DateSources[typeDir]
+	if !exists {
+		sources = defaultDateSources
+	}
+	date, source, err := o.fileDate(fp, sources)
+	if errors.Is(err, ErrorNoCreateDate) {
+		slog.Debug("no date found, file is undated", "path", fp, "date sources", sources)
+		return path.Join(specialSubDir, undatedDir), dateSourceNone, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	sortDir, err := formatPeriod(date, sortType)
+	if err != nil {
+		return "", "", err
+	}
+	return path.Join(specialSubDir, sortDir), source, nil
 }
 
-func (o *Operator) AsyncProcessDir(dirpath string, r bool) (int, error) {
+// AsyncProcessDir processes the files of dirpath on the worker pool and walks its subdirectories.
+// Failed files and directories are recorded by fail, the walk goes on unless ctx is cancelled.
+func (o *Operator) AsyncProcessDir(ctx context.Context, dirpath string) (int, error) {
 	entries, err := os.ReadDir(dirpath)
 	if err != nil {
-		return 0, err
+		o.failDir(dirpath, err)
+		return 0, nil
 	}
 	slog.Debug("", "entry count:", len(entries))
-	if o.Flags.DryRun {
-		os.Exit(1)
-	}
-	total := len(entries)
-	processed := int64(0)
 	extensions := make([]string, 0)
-	var extMutex, unprocMutex = sync.Mutex{}, sync.Mutex{}
-	sem := make(chan struct{}, 10)
+	var extMutex = sync.Mutex{}
 	var wg sync.WaitGroup
 
 	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
 		fp := path.Join(dirpath, entry.Name())
 		if entry.IsDir() {
 			o.SubDirCount++
-			if _, err := o.AsyncProcessDir(fp, true); err != nil {
+			if _, err := o.AsyncProcessDir(ctx, fp); err != nil {
 				return 0, err
 			}
 			continue
 		}
-		if o.skipcheck(fp) {
+		if o.skipcheck(fp) || o.alreadyDone(fp) {
 			continue
 		}
 
-		kind := path.Ext(fp)
-		ext := ""
-		if kind != "" {
-			ext = kind[1:]
-		}
+		ext := o.fileExtension(fp)
 
-		typeDir := o.AddType(ext, fp)
+		match := o.classify(ext, fp)
+		if o.skipNonMedia(match, fp) {
+			continue
+		}
+		match = o.addMatch(match, fp)
 
 		wg.Add(1)
-		sem <- struct{}{} // get slot
-		// TODO how do we handle errors in go calls, can we still just return them?
-		go func(fp, typeDir string, ext string) {
+		o.workers <- struct{}{} // get slot
+		go func(fp string, match Match, ext string) {
 			defer wg.Done()
-			defer func() { <-sem }() // release slot
-			specialSubDir, err := o.getSpecialSubDirNames(typeDir, ext, fp)
-			if err != nil {
+			defer func() { <-o.workers }() // release slot
+			if ctx.Err() != nil {
 				return
 			}
-			if err := o.Copy(o.Flags.DstPath, typeDir, specialSubDir, fp); err != nil {
-				unprocMutex.Lock()
-				o.Storage.Unprocessed = append(o.Storage.Unprocessed, fp)
-				unprocMutex.Unlock()
+			specialSubDir, dateSource, err := o.getSpecialSubDirNames(match.Category, match.separateExt(ext), fp)
+			if err != nil {
+				o.fail(o.failedEntry(fp, match), err)
 				return
 			}
-
-			atomic.AddInt64(&processed, 1)
-			if !r {
-				pct := float64(atomic.LoadInt64(&processed)) / float64(total) * 100
-				if atomic.LoadInt64(&processed)%int64(max(1, total/20)) == 0 {
-					slog.Info("progress", "completed", fmt.Sprintf("%.1f%%", pct))
-				}
+			match.DateSource = dateSource
+			if err := o.Copy(ctx, o.Flags.DstPath, match, specialSubDir, fp); err != nil {
+				o.fail(o.failedEntry(fp, match), err)
+				return
 			}
 			extMutex.Lock()
 			extensions = append(extensions, ext)
 			extMutex.Unlock()
-		}(fp, typeDir, ext)
+		}(fp, match, ext)
 	}
 	wg.Wait()
 	extensions = RemoveDuplicateStr(extensions)
@@ -358,51 +604,50 @@ func (o *Operator) AsyncProcessDir(dirpath string, r bool) (int, error) {
 	return len(extensions), nil
 }
 
-func (o *Operator) ProcessDir(dirpath string, r bool) (int, error) {
+// ProcessDir processes the files of dirpath one by one and walks its subdirectories.
+// Failed files and directories are recorded by fail, the walk goes on unless ctx is cancelled.
+func (o *Operator) ProcessDir(ctx context.Context, dirpath string) (int, error) {
 	entries, err := os.ReadDir(dirpath)
 	if err != nil {
-		return 0, err
+		o.failDir(dirpath, err)
+		return 0, nil
 	}
 	slog.Info("", "entry count:", len(entries))
-	if o.Flags.DryRun {
-		os.Exit(1)
-	}
 
-	total := len(entries)
-	processed := 0
 	subDirCount := 0
 	extensions := make([]string, 0)
 	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
 		fp := path.Join(dirpath, entry.Name())
 		if entry.IsDir() {
 			subDirCount++
-			if _, err := o.ProcessDir(fp, true); err != nil {
+			if _, err := o.ProcessDir(ctx, fp); err != nil {
 				return 0, err
 			}
 			continue
 		}
-		if o.skipcheck(fp) {
+		if o.skipcheck(fp) || o.alreadyDone(fp) {
 			continue
 		}
 
-		kind := path.Ext(fp)
-		ext := ""
-		if kind != "" {
-			ext = kind[1:]
-		}
+		ext := o.fileExtension(fp)
 
-		typeDir := o.AddType(ext, fp)
-		specialSubDir, err := o.getSpecialSubDirNames(typeDir, ext, fp)
-		if err != nil {
-			return 0, err
+		match := o.classify(ext, fp)
+		if o.skipNonMedia(match, fp) {
+			continue
 		}
-		if err := o.Copy(o.Flags.DstPath, typeDir, specialSubDir, fp); err != nil {
-			return 0, err
+		match = o.addMatch(match, fp)
+		specialSubDir, dateSource, err := o.getSpecialSubDirNames(match.Category, match.separateExt(ext), fp)
+		if err != nil {
+			o.fail(o.failedEntry(fp, match), err)
+			continue
 		}
-		processed++
-		percentage := float64(processed) / float64(total) * 100
-		if processed%max(1, total/20) == 0 && !r {
-			slog.Info("progress", "completed", fmt.Sprintf("%.1f%%", percentage))
+		match.DateSource = dateSource
+		if err := o.Copy(ctx, o.Flags.DstPath, match, specialSubDir, fp); err != nil {
+			o.fail(o.failedEntry(fp, match), err)
+			continue
 		}
 		extensions = append(extensions, ext)
 	}
@@ -411,12 +656,41 @@ func (o *Operator) ProcessDir(dirpath string, r bool) (int, error) {
 	return len(extensions), nil
 }
 
-func (o *Operator) Operate() (int, error) {
+// Operate processes the source directory until it's done or ctx is cancelled, e.g. by InterruptContext.
+// Files in flight when ctx is cancelled are cleaned up, Interrupted tells about it afterwards.
+func (o *Operator) Operate(ctx context.Context) (int, error) {
+	o.initPool()
+	defer o.closeExif()
+	defer func() {
+		o.interrupted = ctx.Err() != nil
+	}()
+	// setup is everything since the operator was made: rules, destination directories and the --resume log.
+	o.report.phase("setup", o.report.Started)
+	// --fail-fast cancels runCtx on the first failure, see fail.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	o.cancel = cancel
+
+	start := time.Now()
+	if err := o.prefetchMetadata(runCtx); err != nil {
+		return 0, err
+	}
+	o.report.phase("prescan", start)
+	defer o.saveMetadata()
+	o.progress.start()
+	defer o.progress.finish()
+	defer o.report.phase("process", time.Now())
+
 	switch o.Flags.Async {
 	case true:
-		return o.AsyncProcessDir(o.Flags.SrcPath, false)
+		return o.AsyncProcessDir(runCtx, o.Flags.SrcPath)
 	case false:
-		return o.ProcessDir(o.Flags.SrcPath, false)
+		return o.ProcessDir(runCtx, o.Flags.SrcPath)
 	}
 	return 0, nil
 }
+
+// Interrupted reports whether the last Operate was stopped by its context before it was done.
+func (o *Operator) Interrupted() bool {
+	return o.interrupted
+}
@@ -1,39 +1,400 @@
 package pkg
 
 import (
+	"bytes"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
+	"os/exec"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_initExifTool(t *testing.T) {
+	if _, err := exec.LookPath("exiftool"); err != nil {
+		t.Skip("exiftool isn't installed, it's optional")
+	}
 	o, err := GetNewOperator()
 	require.NoError(t, err)
+	_, err = o.exif()
+	require.NoError(t, err)
 	require.NotNil(t, o.Storage.Exif)
+	require.NoError(t, o.Storage.Exif.Close())
 }
 
-func Test_getFileDate(t *testing.T) {
+func Test_fileDate(t *testing.T) {
 	o, err := GetNewOperator()
 	require.NoError(t, err)
 
 	// injected a date (for testing purposes) into this file with following call:
 	// exiftool -CreateDate="2022:12:08 19:09:53" pkg/evil_gopher.png
 	// evil_gopher.png belongs to https://github.com/MariaLetta/free-gophers-pack/blob/master/characters/png/1.png
+	date, source, err := o.fileDate("./evil_gopher.png", defaultDateSources)
+	require.NoError(t, err)
+	assert.Equal(t, "exif:CreateDate", source)
 	{
-		res, err := o.getFileDate("./evil_gopher.png", "month")
+		res, err := formatPeriod(date, "month")
 		require.NoError(t, err)
-		assert.Equal(t, "2022-12", res)
+		assert.Equal(t, "2022/12", res)
 	}
 	{
-		res, err := o.getFileDate("./evil_gopher.png", "year")
+		res, err := formatPeriod(date, "year")
 		require.NoError(t, err)
 		assert.Equal(t, "2022", res)
 	}
 
-	f, err := os.Create("random.png")
+	f, err := os.Create(path.Join(t.TempDir(), "random.png"))
 	require.NoError(t, err)
-	_, err = o.getFileDate(f.Name(), "year")
+	require.NoError(t, f.Close())
+	_, _, err = o.fileDate(f.Name(), defaultDateSources)
 	require.Equal(t, err, ErrorNoCreateDate)
-	require.NoError(t, os.Remove(f.Name()))
+}
+
+func Test_fileDate_sources(t *testing.T) {
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Pattern, err = CompilePattern("IMG_YEARMONTHDAY_HOURMINUTESECOND.ext")
+	require.NoError(t, err)
+	fp := path.Join(t.TempDir(), "IMG_20190305_101010.jpg")
+	require.NoError(t, os.WriteFile(fp, []byte("no exif"), 0o644))
+	mtime := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(fp, mtime, mtime))
+
+	for _, tc := range []struct {
+		sources []string
+		source  string
+		year    string
+	}{
+		{[]string{"exif:DateTimeOriginal", dateSourceFilename, dateSourceMtime}, dateSourceFilename, "2019"},
+		{[]string{"exif:DateTimeOriginal", dateSourceMtime, dateSourceFilename}, dateSourceMtime, "2021"},
+		{[]string{dateSourceMtime, dateSourceFilename}, dateSourceMtime, "2021"},
+	} {
+		date, source, err := o.fileDate(fp, tc.sources)
+		require.NoError(t, err)
+		assert.Equal(t, tc.source, source, "sources are tried in order: %v", tc.sources)
+		assert.Equal(t, tc.year, date.Format("2006"))
+	}
+
+	o.Pattern = nil
+	_, _, err = o.fileDate(fp, []string{"exif:DateTimeOriginal", dateSourceFilename})
+	assert.Equal(t, ErrorNoCreateDate, err, "filename needs --pattern")
+}
+
+func Test_getSpecialSubDirNames_undated(t *testing.T) {
+	o := &Operator{Storage: *NewStorage()}
+	o.BuildStorageMaps(&Config{Rules: []Rule{
+		{Category: "images", Extensions: []string{"jpg"}, Sort: "year", DateSources: []string{dateSourceFilename}},
+		{Category: "videos", Extensions: []string{"mp4"}, Sort: "month", DateSources: []string{dateSourceMtime}},
+	}})
+	dir := t.TempDir()
+	img, video := path.Join(dir, "a.jpg"), path.Join(dir, "b.mp4")
+	require.NoError(t, os.WriteFile(img, []byte("jpg"), 0o644))
+	require.NoError(t, os.WriteFile(video, []byte("mp4"), 0o644))
+	mtime := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(video, mtime, mtime))
+
+	subDir, source, err := o.getSpecialSubDirNames("images", "jpg", img)
+	require.NoError(t, err)
+	assert.Equal(t, undatedDir, subDir, "files without a date in any source go to undated/")
+	assert.Equal(t, dateSourceNone, source)
+
+	subDir, source, err = o.getSpecialSubDirNames("videos", "mp4", video)
+	require.NoError(t, err)
+	assert.Equal(t, "2021/07", subDir)
+	assert.Equal(t, dateSourceMtime, source)
+}
+
+func Test_AddType(t *testing.T) {
+	o := &Operator{Storage: *NewStorage()}
+	o.BuildStorageMaps(&Config{Rules: []Rule{
+		{Category: "documents", Extensions: []string{"pdf"}},
+		{Category: "special", NameContains: []string{"user1234"}},
+	}})
+
+	assert.Equal(t, "documents", o.AddType("pdf", "/src/invoice.pdf").Category)
+	assert.Equal(t, "special", o.AddType("pdf", "/src/invoice_user1234.pdf").Category)
+	assert.Equal(t, "special", o.AddType("", "/src/user1234").Category)
+	assert.Equal(t, unknown, o.AddType("xyz", "/src/invoice.xyz").Category)
+	// only the file name counts, not the directories above it
+	assert.Equal(t, "documents", o.AddType("pdf", "/user1234/invoice.pdf").Category)
+}
+
+func Test_AddType_priority(t *testing.T) {
+	o := &Operator{Storage: *NewStorage()}
+	o.BuildStorageMaps(&Config{
+		Rules: []Rule{
+			{Category: "documents", Extensions: []string{"pdf"}},
+			{Category: "reports", Extensions: []string{"pdf"}},
+			{Category: "special", NameContains: []string{"user1234"}},
+			{Category: "special2", NameContains: []string{"userABCD"}},
+		},
+		Override: Override{Priority: []string{"special2", "special"}},
+	})
+
+	match := o.AddType("pdf", "/src/user1234_userABCD.pdf")
+	assert.Equal(t, "special2", match.Category)
+	assert.Equal(t, "name_contains:userABCD", match.Criterion)
+	assert.Equal(t, "priority_order over special(name_contains:user1234),documents(extension:pdf),reports(extension:pdf)", match.Reason)
+
+	match = o.AddType("pdf", "/src/invoice.pdf")
+	assert.Equal(t, "documents", match.Category)
+	assert.Equal(t, "rules file order over reports(extension:pdf)", match.Reason)
+
+	o.Storage.Priority = nil
+	match = o.AddType("pdf", "/src/invoice_user1234.pdf")
+	assert.Equal(t, "special", match.Category)
+	assert.Equal(t, "name_contains before extension over documents(extension:pdf),reports(extension:pdf)", match.Reason)
+}
+
+func Test_AddType_extensions(t *testing.T) {
+	o := &Operator{Storage: *NewStorage()}
+	o.BuildStorageMaps(&Config{Rules: []Rule{
+		{Category: "images", Extensions: []string{"jpg"}, Separate: []string{"jpg"}},
+		{Category: "raw", Extensions: []string{"CR2"}, CaseSensitive: true},
+		{Category: "compressed", Extensions: []string{"gz"}},
+		{Category: "backups", Extensions: []string{"tar.gz", "7z.001"}},
+	}})
+
+	assert.Equal(t, "images", o.AddType(o.fileExtension("/src/IMG_1.JPG"), "/src/IMG_1.JPG").Category)
+	assert.Equal(t, "jpg", o.GetSeparateSubdirs("images", "JPG"))
+	assert.Equal(t, "raw", o.AddType(o.fileExtension("/src/IMG_2.CR2"), "/src/IMG_2.CR2").Category)
+	assert.Equal(t, unknown, o.AddType(o.fileExtension("/src/IMG_2.cr2"), "/src/IMG_2.cr2").Category)
+
+	// the longest extension a rule lists wins
+	assert.Equal(t, "TAR.GZ", o.fileExtension("/src/backup.2024.TAR.GZ"))
+	assert.Equal(t, "backups", o.AddType("tar.gz", "/src/backup.tar.gz").Category)
+	assert.Equal(t, "gz", o.fileExtension("/src/access.log.gz"))
+	assert.Equal(t, "7z.001", o.fileExtension("/src/photos.7z.001"))
+	assert.Equal(t, "002", o.fileExtension("/src/photos.7z.002"))
+}
+
+func Test_AddType_sniff(t *testing.T) {
+	dir := t.TempDir()
+	pdf := []byte("%PDF-1.7\n")
+	require.NoError(t, os.WriteFile(path.Join(dir, "fil1"), pdf, 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "photo.jpg"), pdf, 0o644))
+
+	o := &Operator{Storage: *NewStorage(), Flags: Flags{Sniff: true}}
+	rules := []Rule{
+		{Category: "images", Extensions: []string{"jpg"}},
+		{Category: "documents", Extensions: []string{"pdf"}},
+	}
+	o.BuildStorageMaps(&Config{Rules: rules})
+
+	match := o.AddType("", path.Join(dir, "fil1"))
+	assert.Equal(t, "documents", match.Category)
+	assert.Equal(t, "content:pdf", match.Criterion)
+
+	// the extension wins by default, the disagreement is reported
+	match = o.AddType("jpg", path.Join(dir, "photo.jpg"))
+	assert.Equal(t, "images", match.Category)
+	assert.Equal(t, "extension before content over documents(content:pdf); content is pdf (document), not jpg", match.Reason)
+	assert.Equal(t, int64(1), o.mismatches.Load())
+
+	rules[1].SniffOverride = true
+	o = &Operator{Storage: *NewStorage(), Flags: Flags{Sniff: true}}
+	o.BuildStorageMaps(&Config{Rules: rules})
+	match = o.AddType("jpg", path.Join(dir, "photo.jpg"))
+	assert.Equal(t, "documents", match.Category)
+	assert.Equal(t, "content:pdf", match.Criterion)
+}
+
+func Test_Operate_sniffSeparate(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(src, "noext"), []byte("%PDF-1.7\n"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(src, "scan.pdf"), []byte("%PDF-1.7\n"), 0o644))
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, Sniff: true}
+	rules := []Rule{{Category: "documents", Extensions: []string{"pdf"}, Separate: []string{"pdf"}}}
+	o.BuildStorageMaps(&Config{Rules: rules})
+	require.NoError(t, o.CreateSubdirs(dst, rules))
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+
+	assert.FileExists(t, path.Join(dst, "documents", "pdf", "scan.pdf"))
+	assert.FileExists(t, path.Join(dst, "documents", "pdf", "noext"), "files matched by content are separated by the sniffed extension")
+}
+
+func Test_Copy_skipIdenticalHash(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(src, "a.jpg"), []byte("same"), 0o644))
+	require.NoError(t, os.Mkdir(path.Join(dst, "images"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "a.jpg"), []byte("othr"), 0o644))
+	logPath := path.Join(t.TempDir(), "run.csv")
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, LogPath: logPath, OnConflict: ConflictSkipIdentical}
+	o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+	o.CsvHandler, err = NewCSVLogger(logPath, false)
+	require.NoError(t, err)
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, o.CsvHandler.Close())
+
+	hash, err := hashFile(path.Join(src, "a.jpg"))
+	require.NoError(t, err)
+	rows, err := readLog(logPath)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, path.Join(dst, "images", "a_1.jpg"), rows[0]["destinationFilePath"])
+	assert.Equal(t, hash, rows[0]["sha256"], "the hash skip-identical computed is logged without --validate")
+}
+
+func Test_uniqueDstPath_onConflict(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	require.NoError(t, os.MkdirAll(path.Join(dst, "images"), 0o755))
+	require.NoError(t, os.MkdirAll(src, 0o755))
+	require.NoError(t, os.WriteFile(path.Join(src, "a.jpg"), []byte("same"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(src, "b.jpg"), []byte("larger"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "a.jpg"), []byte("other"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "a_1.jpg"), []byte("same"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dst, "images", "b.jpg"), []byte("small"), 0o644))
+
+	decide := func(policy, name string) conflictDecision {
+		o := &Operator{Storage: *NewStorage(), reserved: make(map[string]string)}
+		o.Flags.OnConflict = policy
+		info, err := os.Stat(path.Join(src, name))
+		require.NoError(t, err)
+		d, err := o.uniqueDstPath(dst, "images", "", path.Join(src, name), info)
+		require.NoError(t, err)
+		return d
+	}
+
+	d := decide(ConflictSuffix, "a.jpg")
+	assert.Equal(t, path.Join(dst, "images", "a_2.jpg"), d.Path)
+	assert.False(t, d.Skip)
+
+	d = decide(ConflictSkipIdentical, "a.jpg")
+	assert.Equal(t, path.Join(dst, "images", "a_1.jpg"), d.Path)
+	assert.True(t, d.Skip)
+
+	d = decide(ConflictKeepLarger, "b.jpg")
+	assert.Equal(t, path.Join(dst, "images", "b.jpg"), d.Path)
+	assert.True(t, d.Overwrite)
+
+	d = decide(ConflictSkip, "b.jpg")
+	assert.True(t, d.Skip)
+}
+
+func Test_uniqueDstPath_sameRun(t *testing.T) {
+	for _, mode := range []string{ModeCopy, ModeMove} {
+		for _, policy := range []string{ConflictOverwrite, ConflictKeepNewer, ConflictKeepLarger, ConflictSkip, ConflictSkipIdentical} {
+			src, dst := t.TempDir(), t.TempDir()
+			require.NoError(t, os.Mkdir(path.Join(src, "sub"), 0o755))
+			require.NoError(t, os.WriteFile(path.Join(src, "a.txt"), []byte("first"), 0o644))
+			require.NoError(t, os.WriteFile(path.Join(src, "sub", "a.txt"), []byte("second, larger"), 0o644))
+
+			o, err := GetNewOperator()
+			require.NoError(t, err)
+			o.Flags = Flags{SrcPath: src, DstPath: dst, Mode: mode, OnConflict: policy}
+			rules := []Rule{{Category: "documents", Extensions: []string{"txt"}}}
+			o.BuildStorageMaps(&Config{Rules: rules})
+			require.NoError(t, o.CreateSubdirs(dst, rules))
+			_, err = o.Operate(context.Background())
+			require.NoError(t, err)
+
+			for name, content := range map[string]string{"a.txt": "first", "a_1.txt": "second, larger"} {
+				data, err := os.ReadFile(path.Join(dst, "documents", name))
+				require.NoError(t, err, "%s %s: %s", mode, policy, name)
+				assert.Equal(t, content, string(data), "%s %s: the file of the same run isn't replaced", mode, policy)
+			}
+		}
+	}
+}
+
+func Test_uniqueDstPath_nameTooLong(t *testing.T) {
+	dir := t.TempDir()
+	name := strings.Repeat("a", 251) + ".jpg"
+	require.NoError(t, os.MkdirAll(path.Join(dir, "dst", "images"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("jpg"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "dst", "images", name), []byte("other"), 0o644))
+	info, err := os.Stat(path.Join(dir, name))
+	require.NoError(t, err)
+
+	for _, policy := range []string{ConflictSuffix, ConflictSkipIdentical} {
+		o := &Operator{Storage: *NewStorage(), reserved: make(map[string]string), Flags: Flags{OnConflict: policy}}
+		_, err = o.uniqueDstPath(path.Join(dir, "dst"), "images", "", path.Join(dir, name), info)
+		assert.Error(t, err, "%s: the suffixed name can't be checked, it's returned instead of a panic", policy)
+	}
+}
+
+func Test_Operate_dryRun(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(src, "sub"), 0o755))
+	for name, content := range map[string]string{"a.jpg": "jpg", "sub/a.jpg": "jpeg", "b.pdf": "pdf 1"} {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte(content), 0o644))
+	}
+	rules := []Rule{{Category: "images", Extensions: []string{"jpg"}}, {Category: "documents", Extensions: []string{"pdf"}}}
+
+	for _, async := range []bool{false, true} {
+		dst := path.Join(t.TempDir(), "out")
+		o, err := GetNewOperator()
+		require.NoError(t, err)
+		o.Flags = Flags{SrcPath: src, DstPath: dst, DryRun: true, Async: async, Workers: 2}
+		var plan bytes.Buffer
+		o.progress.stdout = &plan
+		o.BuildStorageMaps(&Config{Rules: rules})
+		require.NoError(t, o.CreateSubdirs(dst, rules))
+		_, err = o.Operate(context.Background())
+		require.NoError(t, err)
+
+		assert.NoDirExists(t, dst, "dry-run creates nothing under dst")
+		lines := strings.Split(strings.TrimSpace(plan.String()), "\n")
+		assert.Len(t, lines, 3)
+		assert.Contains(t, plan.String(), path.Join(dst, "images", "a.jpg")+"\n")
+		assert.Contains(t, plan.String(), path.Join(dst, "images", "a_1.jpg")+" (suffix: taken during this run)\n",
+			"the second a.jpg is planned with the next free name")
+		assert.Contains(t, plan.String(), path.Join(src, "b.pdf")+" -> "+path.Join(dst, "documents", "b.pdf")+"\n")
+		assert.Equal(t, map[string]*planStat{"images": {Files: 2, Bytes: 7}, "documents": {Files: 1, Bytes: 5}}, o.plan)
+	}
+}
+
+func Test_Operate_sortImg(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	mtimes := map[string]time.Time{
+		"a.jpg":     time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC),
+		"clip.mp4":  time.Date(2019, 3, 5, 12, 0, 0, 0, time.UTC),
+		"notes.txt": time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	for name, mtime := range mtimes {
+		require.NoError(t, os.WriteFile(path.Join(src, name), []byte(name), 0o644))
+		require.NoError(t, os.Chtimes(path.Join(src, name), mtime, mtime))
+	}
+	logPath := path.Join(t.TempDir(), "run.csv")
+
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SubCommand: SortImgCmd, SrcPath: src, DstPath: dst, LogPath: logPath, Sort: "month",
+		DateSources: []string{"exif:DateTimeOriginal", dateSourceMtime}}
+	rules := MediaRules(o.Flags.Sort, o.Flags.DateSources)
+	require.NoError(t, o.CreateSubdirs(dst, rules.Rules))
+	o.BuildStorageMaps(rules)
+	o.CsvHandler, err = NewCSVLogger(logPath, false)
+	require.NoError(t, err)
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, o.CsvHandler.Close())
+
+	assert.FileExists(t, path.Join(dst, "images", "2021", "07", "a.jpg"), "images are dated by the date sources")
+	assert.FileExists(t, path.Join(dst, "videos", "2019", "03", "clip.mp4"))
+	assert.Equal(t, []string{path.Join(src, "notes.txt")}, o.Storage.Unprocessed, "sort-img leaves non-media files")
+
+	rows, err := readLog(logPath)
+	require.NoError(t, err)
+	byName := make(map[string]map[string]string)
+	for _, row := range rows {
+		byName[row["fileName"]] = row
+	}
+	require.Len(t, byName, 3)
+	assert.Equal(t, statusSkipped, byName["notes.txt"]["status"])
+	assert.Equal(t, "isn't an image or video", byName["notes.txt"]["reason"])
+	assert.Empty(t, byName["notes.txt"]["destinationFilePath"])
+	assert.Equal(t, dateSourceMtime, byName["a.jpg"]["dateSource"])
 }
@@ -0,0 +1,219 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// tempPrefix starts the hidden names copies are written to before they are renamed to their destination.
+const tempPrefix = ".organizer-"
+
+// tempSuffix ends the hidden names, see tempPrefix.
+const tempSuffix = ".tmp"
+
+// maxTempName is the most bytes of the destination name a temporary name keeps.
+const maxTempName = 200
+
+// createTemp creates the hidden file a copy to dst is written to.
+// It's in the directory of dst, so renaming it to dst is atomic.
+// It gets the mode os.Create would give, os.CreateTemp makes it private to the user.
+func createTemp(dst string) (*os.File, error) {
+	dir, name := filepath.Split(dst)
+	// the random part and the affixes must fit into the 255 bytes most filesystems allow for a name.
+	// the cut steps back to the start of a character, so a UTF-8 name stays valid.
+	if len(name) > maxTempName {
+		cut := maxTempName
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	f, err := os.CreateTemp(dir, tempPrefix+name+".*"+tempSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary destination file for %s: %w", dst, err)
+	}
+	if err := f.Chmod(os.FileMode(0o666 &^ umask)); err != nil {
+		removePartial(f)
+		return nil, fmt.Errorf("failed to set mode of temporary destination file for %s: %w", dst, err)
+	}
+	return f, nil
+}
+
+// isTemp reports whether name is a temporary file left behind by a copy which never finished.
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, tempPrefix) && strings.HasSuffix(name, tempSuffix)
+}
+
+// commitTemp renames the complete temporary file tmp to dst, replacing what's there,
+// and syncs the directory so the new name survives a crash as well.
+func commitTemp(tmp, dst string) error {
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, dst, err)
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// copyFile copies srcFile to dst, preserving the attributes listed in preserve.
+// The copy is written to a temporary file next to dst, synced and then renamed to dst,
+// so dst is either complete or absent, even after a crash. A file already at dst is replaced.
+// With validate, the temporary file is re-read from disk and compared against the sha256 of srcFile,
+// which is returned, mismatches are reported as ErrorHashMismatch and nothing is left at dst.
+// If the copy fails or ctx is cancelled in between, the temporary file is removed.
+func copyFile(ctx context.Context, srcFile *os.File, dst string, validate bool, preserve []string) (string, error) {
+	// stat before reading, reading may change the access time.
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source file:%s:%w", srcFile.Name(), err)
+	}
+	destinationFile, err := createTemp(dst)
+	if err != nil {
+		return "", err
+	}
+	defer func(destinationFile *os.File) {
+		err := destinationFile.Close()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			panic(err)
+		}
+	}(destinationFile)
+
+	// while validating, the source is hashed on the fly so it's read only once.
+	var writer io.Writer = destinationFile
+	srcHash := sha256.New()
+	if validate {
+		writer = io.MultiWriter(destinationFile, srcHash)
+	}
+	_, err = io.Copy(writer, ctxReader{ctx: ctx, r: srcFile})
+	if err != nil {
+		removePartial(destinationFile)
+		return "", fmt.Errorf("failed to copy %s file to %s: %w", srcFile.Name(), dst, err)
+	}
+
+	err = destinationFile.Sync()
+	if err != nil {
+		removePartial(destinationFile)
+		return "", fmt.Errorf("failed to sync destination file:%s:%w", destinationFile.Name(), err)
+	}
+	// closed before the rename, windows can't rename open files.
+	if err := destinationFile.Close(); err != nil {
+		removePartial(destinationFile)
+		return "", fmt.Errorf("failed to close destination file:%s:%w", destinationFile.Name(), err)
+	}
+	if err := preserveAttrs(destinationFile.Name(), srcInfo, preserve); err != nil {
+		removePartial(destinationFile)
+		return "", err
+	}
+
+	var hash string
+	if validate {
+		hash = hex.EncodeToString(srcHash.Sum(nil))
+		if err := validateCopy(hash, destinationFile.Name()); err != nil {
+			removePartial(destinationFile)
+			return hash, err
+		}
+	}
+	return hash, commitTemp(destinationFile.Name(), dst)
+}
+
+// removePartial closes and removes a temporary destination file which didn't become a complete copy.
+func removePartial(f *os.File) {
+	_ = f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		slog.Error("failed to remove partial destination file", "path", f.Name(), "error", err)
+	}
+}
+
+// rename is os.Rename, tests replace it to move across filesystems.
+var rename = os.Rename
+
+// link is os.Link, tests replace it to hardlink across filesystems.
+var link = os.Link
+
+// moveFile renames srcFile to dst. If they are on different filesystems, it falls back to
+// copy, fsync and verify, the source is only removed once the copy is verified.
+// A move keeps times, mode and owner of the file, like rename does.
+func moveFile(ctx context.Context, srcFile *os.File, dst string, entry *LogEntry) error {
+	err := rename(srcFile.Name(), dst)
+	if err == nil {
+		return syncDir(filepath.Dir(dst))
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("failed to move %s to %s: %w", srcFile.Name(), dst, err)
+	}
+
+	entry.Hash, err = copyFile(ctx, srcFile, dst, true, preserveAttributes)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(srcFile.Name()); err != nil {
+		return fmt.Errorf("copied %s to %s but failed to remove the source: %w", srcFile.Name(), dst, err)
+	}
+	entry.Reason = strings.Join([]string{entry.Reason, "cross-filesystem move: copied, verified, removed source"}, "; ")
+	return nil
+}
+
+// linkFile hardlinks or reflinks srcFile to dst depending on mode.
+// If linking isn't possible, e.g. dst is on another filesystem or the filesystem has no reflink support,
+// it falls back to a regular copy and records the reason in entry.
+// A reflink is a new file, it gets the attributes listed in preserve, a hardlink shares them with the source anyway.
+func linkFile(ctx context.Context, srcFile *os.File, dst, mode string, validate bool, preserve []string, entry *LogEntry) error {
+	var err error
+	switch mode {
+	case ModeHardlink:
+		err = link(srcFile.Name(), dst)
+	case ModeReflink:
+		var cloned bool
+		// once the clone is made, errors are the ones a copy would have too, there's no falling back.
+		if cloned, err = reflinkFile(srcFile, dst, preserve); cloned || err == nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown link mode %s", mode)
+	}
+	if err == nil {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%s not possible (%s), fell back to copy", mode, err)
+	slog.Warn(reason, "source", srcFile.Name(), "destination", dst)
+	entry.Operation = ModeCopy
+	entry.Reason = strings.Join([]string{entry.Reason, reason}, "; ")
+	entry.Hash, err = copyFile(ctx, srcFile, dst, validate, preserve)
+	return err
+}
+
+// reflinkFile clones srcFile to dst through a temporary file, like copyFile does.
+// cloned reports whether the filesystem made the clone, errors after it aren't solved by copying instead.
+func reflinkFile(srcFile *os.File, dst string, preserve []string) (cloned bool, err error) {
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return false, err
+	}
+	tmp, err := createTemp(dst)
+	if err != nil {
+		return false, err
+	}
+	if err := reflink(srcFile, tmp); err != nil {
+		removePartial(tmp)
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		removePartial(tmp)
+		return true, err
+	}
+	if err := preserveAttrs(tmp.Name(), srcInfo, preserve); err != nil {
+		removePartial(tmp)
+		return true, err
+	}
+	return true, commitTemp(tmp.Name(), dst)
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"unicode/utf8"
+)
+
+// dirNames returns the names in dir, to check no temporary files are left behind.
+func dirNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func Test_copyFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "a.jpg"), path.Join(dir, "b.jpg")
+	require.NoError(t, os.WriteFile(src, []byte("jpg"), 0o644))
+	require.NoError(t, os.WriteFile(dst, []byte("old content"), 0o644))
+	srcFile, err := os.Open(src)
+	require.NoError(t, err)
+	defer srcFile.Close()
+
+	hash, err := copyFile(context.Background(), srcFile, dst, true, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, hash)
+	content, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "jpg", string(content), "the old file is replaced")
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o666&^umask), info.Mode().Perm(), "the mode os.Create would give")
+	assert.Equal(t, []string{"a.jpg", "b.jpg"}, dirNames(t, dir))
+}
+
+func Test_copyFile_cancelled(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "a.jpg"), path.Join(dir, "b.jpg")
+	require.NoError(t, os.WriteFile(src, []byte("jpg"), 0o644))
+	srcFile, err := os.Open(src)
+	require.NoError(t, err)
+	defer srcFile.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = copyFile(ctx, srcFile, dst, false, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"a.jpg"}, dirNames(t, dir), "neither the destination nor its temporary file is left")
+}
+
+func Test_isTemp(t *testing.T) {
+	f, err := createTemp(path.Join(t.TempDir(), "IMG_1.jpg"))
+	require.NoError(t, err)
+	defer removePartial(f)
+	assert.True(t, isTemp(path.Base(f.Name())))
+	assert.False(t, isTemp("IMG_1.jpg"))
+	assert.False(t, isTemp(".hidden.jpg"))
+}
+
+func Test_createTemp_longName(t *testing.T) {
+	// 199 bytes of "a" and a 2-byte character across the cut at 200.
+	name := strings.Repeat("a", 199) + strings.Repeat("é", 20) + ".jpg"
+	f, err := createTemp(path.Join(t.TempDir(), name))
+	require.NoError(t, err)
+	defer removePartial(f)
+	base := path.Base(f.Name())
+	assert.True(t, utf8.ValidString(base), "the name isn't cut inside a character")
+	assert.True(t, strings.HasPrefix(base, tempPrefix+strings.Repeat("a", 199)+"."), base)
+	assert.LessOrEqual(t, len(base), 255)
+}
+
+// moveSource creates a.jpg in a new directory and opens it.
+func moveSource(t *testing.T) (*os.File, string) {
+	dir := t.TempDir()
+	src := path.Join(dir, "a.jpg")
+	require.NoError(t, os.WriteFile(src, []byte("jpg"), 0o644))
+	srcFile, err := os.Open(src)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srcFile.Close() })
+	return srcFile, dir
+}
+
+// crossFilesystem makes every rename and hardlink fail like across filesystems until the test ends.
+func crossFilesystem(t *testing.T) {
+	rename = func(_, _ string) error { return &os.LinkError{Op: "rename", Err: syscall.EXDEV} }
+	link = func(_, _ string) error { return &os.LinkError{Op: "link", Err: syscall.EXDEV} }
+	t.Cleanup(func() { rename, link = os.Rename, os.Link })
+}
+
+func Test_moveFile(t *testing.T) {
+	srcFile, dir := moveSource(t)
+	dst := path.Join(dir, "b.jpg")
+	entry := LogEntry{}
+	require.NoError(t, moveFile(context.Background(), srcFile, dst, &entry))
+	assert.NoFileExists(t, srcFile.Name())
+	assert.FileExists(t, dst)
+	assert.Empty(t, entry.Hash, "a rename doesn't copy anything to verify")
+}
+
+func Test_moveFile_crossFilesystem(t *testing.T) {
+	crossFilesystem(t)
+	srcFile, dir := moveSource(t)
+	dst := path.Join(dir, "b.jpg")
+	entry := LogEntry{}
+	require.NoError(t, moveFile(context.Background(), srcFile, dst, &entry))
+	assert.NoFileExists(t, srcFile.Name(), "the source is removed once the copy is verified")
+	content, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "jpg", string(content))
+	assert.NotEmpty(t, entry.Hash, "the copy is verified against the sha256 of the source")
+	assert.Contains(t, entry.Reason, "cross-filesystem move")
+}
+
+func Test_moveFile_failedCopy(t *testing.T) {
+	crossFilesystem(t)
+	srcFile, dir := moveSource(t)
+
+	err := moveFile(context.Background(), srcFile, path.Join(dir, "missing", "b.jpg"), &LogEntry{})
+	require.Error(t, err)
+	assert.FileExists(t, srcFile.Name(), "the source survives a copy which failed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = moveFile(ctx, srcFile, path.Join(dir, "b.jpg"), &LogEntry{})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.FileExists(t, srcFile.Name(), "the source survives a cancelled copy")
+	assert.Equal(t, []string{"a.jpg"}, dirNames(t, dir))
+}
+
+func Test_linkFile_hardlink(t *testing.T) {
+	srcFile, dir := moveSource(t)
+	dst := path.Join(dir, "b.jpg")
+	entry := LogEntry{Operation: ModeHardlink}
+	require.NoError(t, linkFile(context.Background(), srcFile, dst, ModeHardlink, false, nil, &entry))
+	srcInfo, err := os.Stat(srcFile.Name())
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(srcInfo, dstInfo), "dst is the same file as the source")
+	assert.Equal(t, ModeHardlink, entry.Operation)
+	assert.Empty(t, entry.Reason)
+}
+
+func Test_linkFile_fallback(t *testing.T) {
+	crossFilesystem(t)
+	for _, mode := range []string{ModeHardlink, ModeReflink} {
+		srcFile, dir := moveSource(t)
+		dst := path.Join(dir, "b.jpg")
+		entry := LogEntry{Operation: mode, Reason: "extension"}
+		require.NoError(t, linkFile(context.Background(), srcFile, dst, mode, true, nil, &entry))
+		content, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, "jpg", string(content))
+		if mode == ModeReflink && entry.Operation == ModeReflink {
+			t.Log("the filesystem of the temp dir supports reflinks, there's nothing to fall back from")
+			continue
+		}
+		assert.Equal(t, ModeCopy, entry.Operation, "undo deletes the copy like any other")
+		assert.Regexp(t, "^extension; "+mode+" not possible \\(.+\\), fell back to copy$", entry.Reason)
+		assert.NotEmpty(t, entry.Hash, "the copy is validated with validate")
+		assert.Equal(t, []string{"a.jpg", "b.jpg"}, dirNames(t, dir))
+	}
+}
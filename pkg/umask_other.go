@@ -0,0 +1,6 @@
+//go:build !unix
+
+package pkg
+
+// umask doesn't exist outside of unix.
+const umask = 0
@@ -0,0 +1,13 @@
+//go:build unix
+
+package pkg
+
+import "syscall"
+
+// umask is the file mode creation mask of the process. It's read once at start,
+// before any copy runs, since reading it means setting it.
+var umask = func() uint32 {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return uint32(mask)
+}()
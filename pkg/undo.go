@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrorChangedSinceRun = errors.New("destination file changed since the run")
+
+// UndoAllRuns makes Undo revert every run of the log.
+const UndoAllRuns = "all"
+
+// Undo reverts the run runID recorded in the CSV log at logPath, newest row first. An empty runID is the last run
+// of the log, UndoAllRuns all of them, logs of older versions without runId are a single run.
+// Copies and links are deleted, moves are put back at their source path, afterward the category, separate and
+// date directories left empty are removed from the destinations the rows were in, see removeEmptyDirs.
+// categories are the ones of the rules the run used, the categories of the log are added to them.
+// Destinations which changed since the run are refused,
+// destinations a newer row wrote again, e.g. a file copied again by --resume, are left to that row.
+// It returns how many rows couldn't be undone.
+func Undo(logPath, runID string, categories []string, dryRun bool) (int, error) {
+	rows, err := readLog(logPath)
+	if err != nil {
+		return 0, err
+	}
+	if runID == "" {
+		runID = lastRun(rows)
+	}
+	slog.Info("undoing run", "log", logPath, "run", runID)
+
+	undone, refused := 0, 0
+	newer := make(map[string]bool) // destinations written by the rows after the current one
+	roots := make(map[string]bool) // --dst of the undone rows, the directory their category directories are in
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		dst := row["destinationFilePath"]
+		if row["status"] == statusSuccess && dst != "" {
+			if newer[dst] {
+				slog.Debug("destination was written again by a newer row, leaving it to that row", "destination", dst)
+				continue
+			}
+			newer[dst] = true
+		}
+		if runID != UndoAllRuns && row["runId"] != runID {
+			continue
+		}
+		if row["status"] != statusSuccess || row["destinationFilePath"] == "" {
+			if (row["status"] == statusFailed || row["status"] == statusFailure) && row["destinationFilePath"] != "" {
+				slog.Warn("failed row is left in place", "destination", row["destinationFilePath"])
+			}
+			continue
+		}
+		if err := undoRow(row, dryRun); err != nil {
+			slog.Error("refusing to undo", "source", row["sourceFilePath"], "destination", row["destinationFilePath"], "error", err)
+			refused++
+			continue
+		}
+		if root, found := categoryRoot(row["destinationFilePath"], row["category"]); found {
+			roots[root] = true
+		}
+		undone++
+	}
+	if !dryRun {
+		categories = slices.Clone(categories)
+		for _, row := range rows {
+			if row["category"] != "" && !slices.Contains(categories, row["category"]) {
+				categories = append(categories, row["category"])
+			}
+		}
+		for root := range roots {
+			for _, category := range categories {
+				removeEmptyDirs(path.Join(root, category))
+			}
+		}
+	}
+	slog.Info("undo finished", "undone", undone, "refused", refused)
+	return refused, nil
+}
+
+// UndoCategories returns the categories of the rules file at rulePath and of sort-img, the category directories
+// undo removes if they're left empty. If the rules can't be read, only the categories of the log are removed.
+func UndoCategories(rulePath string) []string {
+	categories := make([]string, 0)
+	for _, rule := range MediaRules("", nil).Rules {
+		categories = append(categories, rule.Category)
+	}
+	cfg, err := ReadCategories(rulePath)
+	if err != nil {
+		slog.Warn("failed to read the rules, only the category directories of the log are removed", "rules", rulePath, "error", err)
+		return categories
+	}
+	for _, rule := range cfg.Rules {
+		if !slices.Contains(categories, rule.Category) {
+			categories = append(categories, rule.Category)
+		}
+	}
+	return categories
+}
+
+// lastRun returns the runId of the last row which has one, "" for logs of older versions.
+func lastRun(rows []map[string]string) string {
+	for i := len(rows) - 1; i >= 0; i-- {
+		if runID := rows[i]["runId"]; runID != "" {
+			return runID
+		}
+	}
+	return ""
+}
+
+func undoRow(row map[string]string, dryRun bool) error {
+	src, dst := row["sourceFilePath"], row["destinationFilePath"]
+	if err := unchangedSinceRun(row); err != nil {
+		return err
+	}
+
+	if row["operation"] == ModeMove {
+		if _, err := os.Stat(src); err == nil {
+			return fmt.Errorf("source path %s exists again, not overwriting it", src)
+		}
+		if dryRun {
+			fmt.Printf("%s -> %s\n", dst, src)
+			return nil
+		}
+		if err := createDirectory(path.Dir(src)); err != nil {
+			return err
+		}
+		dstFile, err := os.Open(dst)
+		if err != nil {
+			return err
+		}
+		defer func(dstFile *os.File) {
+			err := dstFile.Close()
+			if err != nil {
+				panic(err)
+			}
+		}(dstFile)
+		if err := moveFile(context.Background(), dstFile, src, &LogEntry{}); err != nil {
+			return err
+		}
+		slog.Debug("moved back", "destination", dst, "source", src)
+	} else {
+		if dryRun {
+			fmt.Printf("rm %s\n", dst)
+			return nil
+		}
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+		slog.Debug("removed", "destination", dst)
+	}
+
+	removeEmptyParents(dst, row["category"])
+	return nil
+}
+
+// unchangedSinceRun compares dst against what the log recorded for it:
+// the sha256 if the run validated, otherwise size and mtime.
+func unchangedSinceRun(row map[string]string) error {
+	dst := row["destinationFilePath"]
+	info, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+
+	if hash := row["sha256"]; hash != "" {
+		dstHash, err := hashFile(dst)
+		if err != nil {
+			return err
+		}
+		if dstHash != hash {
+			return fmt.Errorf("%w: sha256 %s != %s", ErrorChangedSinceRun, dstHash, hash)
+		}
+		return nil
+	}
+
+	if row["size"] == "" || row["modTime"] == "" {
+		return fmt.Errorf("log has neither sha256 nor size and modTime to check %s against", dst)
+	}
+	size, err := strconv.ParseInt(row["size"], 10, 64)
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return fmt.Errorf("%w: size %d != %d", ErrorChangedSinceRun, info.Size(), size)
+	}
+	if modTime := info.ModTime().UTC().Format(time.RFC3339Nano); modTime != row["modTime"] {
+		return fmt.Errorf("%w: modTime %s != %s", ErrorChangedSinceRun, modTime, row["modTime"])
+	}
+	return nil
+}
+
+// categoryRoot returns the directory the category directory of the destination dst is in, the --dst of its run.
+// Logs of older versions have no category, false is returned for them.
+func categoryRoot(dst, category string) (string, bool) {
+	if category == "" {
+		return "", false
+	}
+	for dir := path.Dir(dst); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if path.Base(dir) == category {
+			return path.Dir(dir), true
+		}
+	}
+	return "", false
+}
+
+// removeEmptyDirs removes the category directory categoryDir and the directories below it which are empty,
+// deepest first, so a category which only holds empty separate or date directories goes too.
+// These are the directories CreateSubdirs and the copies made, directories with files are kept.
+func removeEmptyDirs(categoryDir string) {
+	dirs := make([]string, 0)
+	err := filepath.WalkDir(categoryDir, func(fp string, d os.DirEntry, err error) error {
+		if err != nil {
+			// missing or unreadable, there's nothing undo can remove.
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			dirs = append(dirs, fp)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("failed to look for empty directories", "path", categoryDir, "error", err)
+	}
+	for _, dir := range slices.Backward(dirs) {
+		if err := os.Remove(dir); err == nil {
+			slog.Debug("removed empty directory", "path", dir)
+		}
+	}
+}
+
+// removeEmptyParents removes the directories above fp which are empty now,
+// walking up until the category directory, which is the last one it may remove.
+func removeEmptyParents(fp, category string) {
+	for dir := path.Dir(fp); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			// not empty, or not ours to remove
+			return
+		}
+		slog.Debug("removed empty directory", "path", dir)
+		if category == "" || path.Base(dir) == category {
+			return
+		}
+	}
+}
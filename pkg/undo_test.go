@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+// runLogged organizes src into dst with mode, appending to the log at logPath, and returns the runId.
+func runLogged(t *testing.T, src, dst, logPath, mode string) string {
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, LogPath: logPath, Mode: mode, OnConflict: ConflictSuffix}
+	rules := []Rule{
+		{Category: "images", Extensions: []string{"jpg", "png"}, Separate: []string{"png"}},
+		{Category: "documents", Extensions: []string{"pdf"}, Separate: []string{"pdf"}},
+	}
+	o.BuildStorageMaps(&Config{Rules: rules})
+	require.NoError(t, o.CreateSubdirs(dst, rules))
+	o.CsvHandler, err = NewCSVLogger(logPath, true)
+	require.NoError(t, err)
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, o.CsvHandler.Close())
+	return o.RunID
+}
+
+// undoSource creates src with a.jpg and sub/b.jpg.
+func undoSource(t *testing.T) string {
+	src := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(src, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(src, "a.jpg"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(src, "sub", "b.jpg"), []byte("b"), 0o644))
+	return src
+}
+
+func Test_Undo_copy(t *testing.T) {
+	src, dst := undoSource(t), t.TempDir()
+	logPath := path.Join(t.TempDir(), "run.csv")
+	runLogged(t, src, dst, logPath, ModeCopy)
+	require.FileExists(t, path.Join(dst, "images", "a.jpg"))
+
+	require.DirExists(t, path.Join(dst, "documents", "pdf"))
+	require.NoError(t, os.Mkdir(path.Join(dst, "mine"), 0o755))
+
+	refused, err := Undo(logPath, "", []string{"documents"}, false)
+	require.NoError(t, err)
+	assert.Zero(t, refused)
+	assert.NoDirExists(t, path.Join(dst, "images"), "the copies, the empty separate and category directories are removed")
+	assert.NoDirExists(t, path.Join(dst, "documents"), "categories no file went to are removed too")
+	assert.DirExists(t, path.Join(dst, "mine"), "directories of no category are left alone")
+	assert.FileExists(t, path.Join(src, "a.jpg"))
+	assert.FileExists(t, path.Join(src, "sub", "b.jpg"))
+}
+
+func Test_Undo_move(t *testing.T) {
+	src, dst := undoSource(t), t.TempDir()
+	logPath := path.Join(t.TempDir(), "run.csv")
+	runLogged(t, src, dst, logPath, ModeMove)
+	require.NoFileExists(t, path.Join(src, "sub", "b.jpg"))
+
+	refused, err := Undo(logPath, "", nil, false)
+	require.NoError(t, err)
+	assert.Zero(t, refused)
+	content, err := os.ReadFile(path.Join(src, "sub", "b.jpg"))
+	require.NoError(t, err, "moves are put back at their source")
+	assert.Equal(t, "b", string(content))
+	assert.FileExists(t, path.Join(src, "a.jpg"))
+	assert.NoFileExists(t, path.Join(dst, "images", "b.jpg"))
+}
+
+func Test_Undo_changed(t *testing.T) {
+	src, dst := undoSource(t), t.TempDir()
+	logPath := path.Join(t.TempDir(), "run.csv")
+	runLogged(t, src, dst, logPath, ModeCopy)
+	changed := path.Join(dst, "images", "a.jpg")
+	require.NoError(t, os.WriteFile(changed, []byte("edited since"), 0o644))
+
+	refused, err := Undo(logPath, "", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, refused)
+	assert.FileExists(t, changed, "a destination which changed since the run is kept")
+	assert.NoFileExists(t, path.Join(dst, "images", "b.jpg"))
+}
+
+func Test_Undo_runs(t *testing.T) {
+	src, dst := undoSource(t), t.TempDir()
+	logPath := path.Join(t.TempDir(), "run.csv")
+	first := runLogged(t, src, dst, logPath, ModeCopy)
+	// the second run copies everything again, with a suffix.
+	runLogged(t, src, dst, logPath, ModeCopy)
+	require.FileExists(t, path.Join(dst, "images", "a_1.jpg"))
+
+	refused, err := Undo(logPath, "", nil, false)
+	require.NoError(t, err)
+	assert.Zero(t, refused)
+	assert.NoFileExists(t, path.Join(dst, "images", "a_1.jpg"), "the last run is undone")
+	assert.FileExists(t, path.Join(dst, "images", "a.jpg"), "earlier runs are kept")
+
+	refused, err = Undo(logPath, first, nil, false)
+	require.NoError(t, err)
+	assert.Zero(t, refused)
+	assert.NoFileExists(t, path.Join(dst, "images", "a.jpg"))
+}
+
+func Test_Undo_rewritten(t *testing.T) {
+	src, dst := undoSource(t), t.TempDir()
+	logPath := path.Join(t.TempDir(), "run.csv")
+	runLogged(t, src, dst, logPath, ModeCopy)
+	// the copy got lost, --resume copies it again to the same destination.
+	require.NoError(t, os.Remove(path.Join(dst, "images", "a.jpg")))
+	o, err := GetNewOperator()
+	require.NoError(t, err)
+	o.Flags = Flags{SrcPath: src, DstPath: dst, LogPath: logPath, Resume: logPath, OnConflict: ConflictSuffix}
+	o.BuildStorageMaps(&Config{Rules: []Rule{{Category: "images", Extensions: []string{"jpg"}}}})
+	require.NoError(t, o.LoadResume(logPath))
+	o.CsvHandler, err = NewCSVLogger(logPath, true)
+	require.NoError(t, err)
+	_, err = o.Operate(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, o.CsvHandler.Close())
+
+	refused, err := Undo(logPath, UndoAllRuns, nil, false)
+	require.NoError(t, err)
+	assert.Zero(t, refused, "the older row of the copied again destination is left to the newer one")
+	assert.NoDirExists(t, path.Join(dst, "images"))
+}
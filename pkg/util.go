@@ -1,5 +1,36 @@
 package pkg
 
+import (
+	"path"
+	"strings"
+)
+
+// extension returns the extension of fp without the leading dot.
+func extension(fp string) string {
+	kind := path.Ext(fp)
+	if kind == "" {
+		return ""
+	}
+	return kind[1:]
+}
+
+// extensionCandidates returns every extension of fp without the leading dot, longest first,
+// e.g. "tar.gz" and "gz" for backup.tar.gz. The leading dot of hidden files doesn't start an extension.
+func extensionCandidates(fp string) []string {
+	name := strings.TrimLeft(path.Base(fp), ".")
+	candidates := make([]string, 0)
+	for {
+		_, rest, found := strings.Cut(name, ".")
+		if !found {
+			return candidates
+		}
+		if rest != "" {
+			candidates = append(candidates, rest)
+		}
+		name = rest
+	}
+}
+
 func RemoveDuplicateStr(strSlice []string) []string {
 	allKeys := make(map[string]bool)
 	list := []string{}
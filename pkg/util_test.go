@@ -0,0 +1,14 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_extensionCandidates(t *testing.T) {
+	assert.Equal(t, []string{"tar.gz", "gz"}, extensionCandidates("/src/backup.tar.gz"))
+	assert.Equal(t, []string{"jpg"}, extensionCandidates("/src/IMG_20220830_195427.jpg"))
+	assert.Equal(t, []string{"yaml"}, extensionCandidates("/src/.config.yaml"))
+	assert.Empty(t, extensionCandidates("/src/fil1"))
+	assert.Empty(t, extensionCandidates("/src/.bashrc"))
+}
@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrorHashMismatch = errors.New("sha256 of destination doesn't match the source")
+
+// hashFile returns the hex encoded sha256 of the file at fp.
+func hashFile(fp string) (string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}(f)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validateCopy re-reads dst from disk and compares its sha256 against srcHash.
+func validateCopy(srcHash, dst string) error {
+	dstHash, err := hashFile(dst)
+	if err != nil {
+		return fmt.Errorf("failed to hash destination file:%s:%w", dst, err)
+	}
+	if dstHash != srcHash {
+		return fmt.Errorf("%w: %s != %s", ErrorHashMismatch, srcHash, dstHash)
+	}
+	return nil
+}
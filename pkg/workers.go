@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"log/slog"
+	"runtime"
+)
+
+const (
+	// hddWorkers is the default number of workers when the source or the destination is a spinning disk,
+	// more parallel copies only make its head seek back and forth.
+	hddWorkers = 2
+	// minWorkers is the least number of default workers on other disks, copies wait on I/O more than on the CPU.
+	minWorkers = 4
+	// openFilesPerCopy is how many files a single Copy holds open: its source and its destination.
+	openFilesPerCopy = 2
+)
+
+// defaultWorkers returns the number of workers for copying from src to dst, depending on their disks.
+func defaultWorkers(src, dst string) (int, string) {
+	if rotational(src) || rotational(dst) {
+		return hddWorkers, "hdd"
+	}
+	return max(minWorkers, runtime.NumCPU()), "ssd"
+}
+
+// initPool sizes the worker pool of the async traversal with --workers, and the copy slots with --max-open-files.
+// Both are shared by the whole traversal, not per directory.
+func (o *Operator) initPool() {
+	workers, disk := o.Flags.Workers, "set by --workers"
+	if workers <= 0 {
+		workers, disk = defaultWorkers(o.Flags.SrcPath, o.Flags.DstPath)
+	}
+	maxOpenFiles := o.Flags.MaxOpenFiles
+	if maxOpenFiles <= 0 {
+		// half of the limit is left for the log, exiftool pipes and everything else.
+		maxOpenFiles = openFilesLimit() / 2
+	}
+	copies := max(1, maxOpenFiles/openFilesPerCopy)
+
+	o.workers = make(chan struct{}, workers)
+	o.copySlots = make(chan struct{}, copies)
+	if o.Flags.Async {
+		slog.Info("worker pool", "workers", workers, "disk", disk, "max open files", maxOpenFiles)
+	}
+}
+
+// acquireCopySlot blocks until a copy may open its files, release it with releaseCopySlot.
+func (o *Operator) acquireCopySlot() {
+	if o.copySlots != nil {
+		o.copySlots <- struct{}{}
+	}
+}
+
+func (o *Operator) releaseCopySlot() {
+	if o.copySlots != nil {
+		<-o.copySlots
+	}
+}
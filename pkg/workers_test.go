@@ -0,0 +1,20 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path"
+	"testing"
+)
+
+func Test_initPool(t *testing.T) {
+	o := &Operator{Flags: Flags{Workers: 3, MaxOpenFiles: 5}}
+	o.initPool()
+	assert.Equal(t, 3, cap(o.workers))
+	assert.Equal(t, 2, cap(o.copySlots), "each copy holds 2 files")
+
+	// defaults, the destination doesn't have to exist yet
+	o = &Operator{Flags: Flags{SrcPath: t.TempDir(), DstPath: path.Join(t.TempDir(), "missing", "dst")}}
+	o.initPool()
+	assert.GreaterOrEqual(t, cap(o.workers), hddWorkers)
+	assert.Positive(t, cap(o.copySlots))
+}